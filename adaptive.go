@@ -0,0 +1,60 @@
+package octree
+
+// Push the values of an overfull leaf at the given level down into new
+// children. If they all land in the same child, that child is split again,
+// until we reach the deepest level allowed.
+func (o *Octree) splitLeaf(n *node, level uint) {
+	if int(level) >= o.depth-1 {
+		return
+	}
+	n.children = new([8]*node)
+	for _, v := range n.values {
+		slot := childSlot(o.key(v.r, v.g, v.b), level+1)
+		child := n.children[slot]
+		if child == nil {
+			child = o.newNode()
+			n.children[slot] = child
+		}
+		child.count += v.count
+		child.addTotals(v.r, v.g, v.b, v.count)
+		child.values = append(child.values, v)
+	}
+	n.values = nil
+	for _, child := range n.children {
+		if child != nil && len(child.values) > o.maxLeafSize {
+			o.splitLeaf(child, level+1)
+		}
+	}
+}
+
+// If every child of n is a leaf, and between them they hold no more than half
+// of maxLeafSize values, turn n back into a single leaf. Waiting until the
+// children are half empty avoids splitting and merging over and over when a
+// leaf hovers around maxLeafSize. Returns whether n is now a leaf.
+func (o *Octree) mergeChildren(n *node) bool {
+	if n.children == nil {
+		return true
+	}
+	total := 0
+	for _, child := range n.children {
+		if child == nil {
+			continue
+		}
+		if child.children != nil {
+			return false
+		}
+		total += len(child.values)
+	}
+	if total > o.maxLeafSize/2 {
+		return false
+	}
+	var values []*value
+	for _, child := range n.children {
+		if child != nil {
+			values = append(values, child.values...)
+		}
+	}
+	n.children = nil
+	n.values = values
+	return true
+}
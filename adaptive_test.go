@@ -0,0 +1,203 @@
+package octree
+
+import (
+	"math/rand"
+
+	"gopkg.in/check.v1"
+)
+
+type AdaptiveSuite struct{}
+
+var _ = check.Suite(&AdaptiveSuite{})
+
+func (*AdaptiveSuite) TestNewAdaptive(c *check.C) {
+	oct, err := NewOctree(9, WithAdaptiveLeaves(4))
+	c.Assert(err, check.IsNil)
+	c.Check(oct.root, check.DeepEquals, &node{})
+	c.Check(oct.maxLeafSize, check.Equals, 4)
+	_, err = NewOctree(5, WithAdaptiveLeaves(-1))
+	c.Check(err, check.ErrorMatches, "Invalid max leaf size: -1")
+	_, err = NewOctree(5, WithAdaptiveLeaves(4), WithStorage(DenseStorage))
+	c.Check(err, check.ErrorMatches, "Adaptive leaves need sparse storage")
+	// Only sparse trees can go all the way down to single colors
+	_, err = NewOctree(8, WithStorage(DenseStorage))
+	c.Check(err, check.ErrorMatches, "Invalid octree depth: 8")
+	oct, err = NewOctree(9)
+	c.Assert(err, check.IsNil)
+	c.Check(oct.root, check.NotNil)
+}
+
+func (*AdaptiveSuite) TestSplit(c *check.C) {
+	oct, err := NewOctree(9, WithAdaptiveLeaves(2))
+	c.Assert(err, check.IsNil)
+	oct.Add(0x00, 0x00, 0x00)
+	oct.Add(0xFF, 0xFF, 0xFF)
+	// Still fits in the root
+	c.Check(oct.root.children, check.IsNil)
+	c.Check(oct.root.values, check.HasLen, 2)
+	oct.Add(0xFF, 0xFF, 0xFF)
+	c.Check(oct.root.children, check.IsNil)
+	oct.Add(0x80, 0x00, 0x00)
+	// 3 distinct values is too many, so we split, but only one level
+	c.Assert(oct.root.children, check.NotNil)
+	c.Check(oct.root.values, check.IsNil)
+	c.Check(oct.root.count, check.Equals, uint64(4))
+	c.Check(oct.root.children[0].values, check.DeepEquals,
+		[]*value{{count: 1}})
+	c.Check(oct.root.children[4].values, check.DeepEquals,
+		[]*value{{r: 0x80, count: 1}})
+	c.Check(oct.root.children[7].values, check.DeepEquals,
+		[]*value{{r: 0xFF, g: 0xFF, b: 0xFF, count: 2}})
+	c.Check(oct.root.children[7].count, check.Equals, uint64(2))
+}
+
+func (*AdaptiveSuite) TestSplitRepeatedly(c *check.C) {
+	oct, err := NewOctree(9, WithAdaptiveLeaves(2))
+	c.Assert(err, check.IsNil)
+	// These only differ in the lowest bit, so they don't separate until
+	// the very last level
+	oct.Add(0x00, 0x00, 0x00)
+	oct.Add(0x00, 0x00, 0x01)
+	oct.Add(0x00, 0x01, 0x00)
+	n := oct.root
+	for level := 1; level < 8; level++ {
+		c.Assert(n.children, check.NotNil)
+		n = n.children[0]
+		c.Assert(n, check.NotNil)
+		c.Check(n.count, check.Equals, uint64(3))
+	}
+	c.Check(n.children[0].values, check.DeepEquals, []*value{{count: 1}})
+	c.Check(n.children[1].values, check.DeepEquals, []*value{{b: 1, count: 1}})
+	c.Check(n.children[2].values, check.DeepEquals, []*value{{g: 1, count: 1}})
+	c.Check(oct.FindClosest(0, 0, 1), check.DeepEquals, value{b: 1, count: 1})
+}
+
+func (*AdaptiveSuite) TestSplitStopsAtDepth(c *check.C) {
+	oct, err := NewOctree(2, WithAdaptiveLeaves(1))
+	c.Assert(err, check.IsNil)
+	oct.Add(0x00, 0x00, 0x00)
+	oct.Add(0x00, 0x00, 0x01)
+	oct.Add(0x00, 0x01, 0x00)
+	c.Assert(oct.root.children, check.NotNil)
+	leaf := oct.root.children[0]
+	c.Check(leaf.children, check.IsNil)
+	c.Check(leaf.values, check.HasLen, 3)
+}
+
+func (*AdaptiveSuite) TestMerge(c *check.C) {
+	oct, err := NewOctree(9, WithAdaptiveLeaves(4))
+	c.Assert(err, check.IsNil)
+	for i := 0; i < 5; i++ {
+		oct.Add(uint8(i*0x30), 0, 0)
+	}
+	c.Assert(oct.root.children, check.NotNil)
+	// Down to 3 values is still more than half, so nothing merges
+	c.Check(oct.Remove(0x00, 0, 0), check.Equals, true)
+	c.Check(oct.Remove(0x30, 0, 0), check.Equals, true)
+	c.Check(oct.root.children, check.NotNil)
+	c.Check(oct.Remove(0x60, 0, 0), check.Equals, true)
+	c.Check(oct.root.children, check.IsNil)
+	c.Check(oct.root.values, check.DeepEquals,
+		[]*value{{r: 0x90, count: 1}, {r: 0xC0, count: 1}})
+	c.Check(oct.count, check.Equals, uint64(2))
+	c.Check(oct.root.count, check.Equals, uint64(2))
+}
+
+func (*AdaptiveSuite) TestRemoveMissing(c *check.C) {
+	for _, options := range [][]Option{
+		nil,
+		{WithStorage(SparseStorage)},
+		{WithAdaptiveLeaves(2)},
+	} {
+		oct, err := NewOctree(3, options...)
+		c.Assert(err, check.IsNil)
+		c.Check(oct.Remove(1, 2, 3), check.Equals, false)
+		oct.Add(1, 2, 3)
+		c.Check(oct.Remove(1, 2, 4), check.Equals, false)
+		c.Check(oct.Remove(0xFF, 2, 3), check.Equals, false)
+		c.Check(oct.count, check.Equals, uint64(1))
+		c.Check(oct.Remove(1, 2, 3), check.Equals, true)
+		c.Check(oct.Remove(1, 2, 3), check.Equals, false)
+		c.Check(oct.count, check.Equals, uint64(0))
+		c.Check(oct.FindClosest(1, 2, 3), check.DeepEquals, value{})
+	}
+}
+
+func (*AdaptiveSuite) TestRemoveDense(c *check.C) {
+	oct, err := NewOctree(3)
+	c.Assert(err, check.IsNil)
+	oct.Add(0xFF, 0xFF, 0xFF)
+	oct.Add(0xFF, 0xFF, 0xFF)
+	oct.Add(0xFE, 0xFF, 0xFF)
+	c.Check(oct.Remove(0xFF, 0xFF, 0xFF), check.Equals, true)
+	c.Check(oct.count, check.Equals, uint64(2))
+	c.Check(oct.layerCounts[0][7], check.Equals, uint32(2))
+	c.Check(oct.layerCounts[1][63], check.Equals, uint32(2))
+	c.Check(oct.values[63], check.DeepEquals, []*value{
+		{r: 0xFF, g: 0xFF, b: 0xFF, count: 1},
+		{r: 0xFE, g: 0xFF, b: 0xFF, count: 1},
+	})
+	c.Check(oct.Remove(0xFF, 0xFF, 0xFF), check.Equals, true)
+	c.Check(oct.Remove(0xFE, 0xFF, 0xFF), check.Equals, true)
+	c.Check(oct.values[63], check.IsNil)
+	c.Check(oct.layerCounts[0][7], check.Equals, uint32(0))
+}
+
+func (*AdaptiveSuite) TestRemoveSparsePrunes(c *check.C) {
+	oct, err := NewOctree(4, WithStorage(SparseStorage))
+	c.Assert(err, check.IsNil)
+	oct.Add(0xFF, 0xFF, 0xFF)
+	oct.Add(0x00, 0x00, 0x00)
+	c.Check(oct.Remove(0xFF, 0xFF, 0xFF), check.Equals, true)
+	c.Check(oct.root.children[7], check.IsNil)
+	c.Check(oct.root.children[0], check.NotNil)
+}
+
+func (*AdaptiveSuite) TestAdaptiveMatchesDense(c *check.C) {
+	dense, err := NewOctree(5)
+	c.Assert(err, check.IsNil)
+	adaptive, err := NewOctree(9, WithAdaptiveLeaves(8))
+	c.Assert(err, check.IsNil)
+	rng := rand.New(rand.NewSource(1))
+	var added [][3]uint8
+	for i := 0; i < 2000; i++ {
+		// Bunch most of the colors up in one corner
+		rgb := [3]uint8{uint8(rng.Intn(32)), uint8(rng.Intn(32)), uint8(rng.Intn(256))}
+		dense.Add(rgb[0], rgb[1], rgb[2])
+		adaptive.Add(rgb[0], rgb[1], rgb[2])
+		added = append(added, rgb)
+	}
+	for _, rgb := range added[:1500] {
+		c.Assert(dense.Remove(rgb[0], rgb[1], rgb[2]), check.Equals, true)
+		c.Assert(adaptive.Remove(rgb[0], rgb[1], rgb[2]), check.Equals, true)
+	}
+	c.Check(adaptive.count, check.Equals, dense.count)
+	c.Check(checkLeafSizes(c, adaptive.root, 8), check.Equals, adaptive.count)
+	for i := 0; i < 300; i++ {
+		r, g, b := uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256))
+		d := dense.FindClosest(r, g, b)
+		a := adaptive.FindClosest(r, g, b)
+		c.Check(dist2ToV(r, g, b, &a), check.Equals, dist2ToV(r, g, b, &d))
+	}
+}
+
+// Check that no leaf holds more than maxLeafSize values, and that the counts
+// of each node add up. Returns the total count.
+func checkLeafSizes(c *check.C, n *node, maxLeafSize int) uint64 {
+	total := uint64(0)
+	if n.children == nil {
+		c.Check(len(n.values) <= maxLeafSize, check.Equals, true)
+		for _, v := range n.values {
+			total += v.count
+		}
+	} else {
+		c.Check(n.values, check.IsNil)
+		for _, child := range n.children {
+			if child != nil {
+				total += checkLeafSizes(c, child, maxLeafSize)
+			}
+		}
+	}
+	c.Check(n.count, check.Equals, total)
+	return total
+}
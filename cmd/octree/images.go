@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jameinel/octree"
+)
+
+// Decode a PNG, JPEG or GIF file.
+func loadImage(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return img, nil
+}
+
+// The extensions of the image files that are found in directories.
+var imageExtensions = map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".gif": true}
+
+// Expand args into a list of image files. Files are used as they are, and
+// directories are replaced by the image files directly inside them.
+func imageFiles(args []string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		entries, err := os.ReadDir(arg)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			ext := strings.ToLower(filepath.Ext(entry.Name()))
+			if !entry.IsDir() && imageExtensions[ext] {
+				files = append(files, filepath.Join(arg, entry.Name()))
+			}
+		}
+	}
+	return files, nil
+}
+
+// Add every pixel of img to tree. Fully transparent pixels have no color, so
+// they are skipped. Returns the number of pixels in img, skipped or not.
+func addImage(tree *octree.Octree, img image.Image) uint64 {
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, ok := octree.PixelRGB(img, x, y)
+			if ok {
+				tree.Add(r, g, b)
+			}
+		}
+	}
+	return uint64(bounds.Dx()) * uint64(bounds.Dy())
+}
+
+// Warn that the counts of tree stopped short of the number of colors added.
+func warnSaturated(w io.Writer, command string, tree *octree.Octree) {
+	if tree.Saturated() {
+		fmt.Fprintf(w, "octree %s: warning: too many pixels to count, so the counts are too low\n",
+			command)
+	}
+}
@@ -0,0 +1,89 @@
+// Command octree gives scripts access to the octree package.
+//
+// Usage:
+//
+//	octree <command> [flags] [args]
+//
+// The commands are:
+//
+//	palette    extract a palette of the most representative colors of images
+//	remap      map images onto the colors of a palette
+//	stats      report on the colors used by images
+//
+// Run "octree <command> -h" for the flags of a command.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// A command is run with the arguments that follow its name.
+type command struct {
+	summary string
+	run     func(args []string, stdout, stderr io.Writer) error
+}
+
+var commands = map[string]command{
+	"palette": {"extract a palette of the most representative colors of images", runPalette},
+	"remap":   {"map images onto the colors of a palette", runRemap},
+	"stats":   {"report on the colors used by images", runStats},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// Run the command named by args[0], and return the exit status.
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "help" {
+		usage(stderr)
+		return 2
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "octree: unknown command %q\n", args[0])
+		usage(stderr)
+		return 2
+	}
+	err := cmd.run(args[1:], stdout, stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "octree %s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: octree <command> [flags] [args]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "The commands are:")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "\t%-10s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `Run "octree <command> -h" for the flags of a command.`)
+}
+
+// A FlagSet for a command, that reports errors rather than exiting.
+func newFlagSet(name, args string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "usage: octree %s [flags] %s\n", name, args)
+		flags.PrintDefaults()
+	}
+	return flags
+}
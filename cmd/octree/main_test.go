@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jameinel/octree"
+	"gopkg.in/check.v1"
+)
+
+func TestAll(t *testing.T) {
+	check.TestingT(t)
+}
+
+type MainSuite struct{}
+
+var _ = check.Suite(&MainSuite{})
+
+// Run the command line, returning the exit status, stdout and stderr.
+func runCommand(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	status := run(args, &stdout, &stderr)
+	return status, stdout.String(), stderr.String()
+}
+
+// Write an image to dir as a PNG, returning its path.
+func writePNG(c *check.C, dir, name string, img image.Image) string {
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path)
+	c.Assert(err, check.IsNil)
+	defer f.Close()
+	c.Assert(png.Encode(f, img), check.IsNil)
+	return path
+}
+
+// An image with the top rows filled with one color, and the rest with others.
+func stripedImage(colors ...color.Color) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4*len(colors)))
+	for y := 0; y < img.Bounds().Dy(); y++ {
+		for x := 0; x < 4; x++ {
+			img.Set(x, y, colors[y/4])
+		}
+	}
+	return img
+}
+
+func (*MainSuite) TestUsage(c *check.C) {
+	status, stdout, stderr := runCommand()
+	c.Check(status, check.Equals, 2)
+	c.Check(stdout, check.Equals, "")
+	c.Check(stderr, check.Matches, `(?s)usage: octree <command> .*palette .*`)
+	status, _, stderr = runCommand("paint")
+	c.Check(status, check.Equals, 2)
+	c.Check(stderr, check.Matches, `(?s)octree: unknown command "paint"\nusage: .*`)
+}
+
+func (*MainSuite) TestWarnSaturated(c *check.C) {
+	tree, err := octree.NewOctree(2)
+	c.Assert(err, check.IsNil)
+	var stderr bytes.Buffer
+	warnSaturated(&stderr, "stats", tree)
+	c.Check(stderr.String(), check.Equals, "")
+	// A tree that has counted as much as a uint32 can hold
+	data, err := tree.MarshalBinary()
+	c.Assert(err, check.IsNil)
+	data = data[:len(data)-4]
+	binary.LittleEndian.PutUint64(data[16:], math.MaxUint32)
+	binary.LittleEndian.PutUint32(data[24:], 1)
+	data = append(data, 0, 0, 0)
+	data = binary.LittleEndian.AppendUint64(data, math.MaxUint32)
+	data = binary.LittleEndian.AppendUint32(data, crc32.ChecksumIEEE(data))
+	c.Assert(tree.UnmarshalBinary(data), check.IsNil)
+	tree.Add(0, 0, 0)
+	warnSaturated(&stderr, "stats", tree)
+	c.Check(stderr.String(), check.Equals,
+		"octree stats: warning: too many pixels to count, so the counts are too low\n")
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/jameinel/octree"
+)
+
+// Extract one palette from all of the images named on the command line.
+func runPalette(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("palette", "image...", stderr)
+	n := flags.Int("n", 16, "the number of colors in the palette")
+	depth := flags.Int("depth", 6, "the depth of the octree, 1 to 9")
+	metricName := flags.String("metric", "euclidean", "the distance metric, euclidean or redmean")
+	quantizerName := flags.String("quantizer", "octree", "the quantizer, octree, mediancut or wu")
+	format := flags.String("format", "",
+		"the output format, hex, json, or a palette format (gpl, act, ase, paintnet);\n"+
+			"the default is from the extension of -o, or hex")
+	output := flags.String("o", "", "write the palette to this file rather than stdout")
+	if err := flags.Parse(args); err != nil {
+		return flag.ErrHelp
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+	metric, err := octree.ParseMetric(*metricName)
+	if err != nil {
+		return err
+	}
+	quantizer, err := octree.ParseQuantizer(*quantizerName)
+	if err != nil {
+		return err
+	}
+	if *format == "" {
+		*format = "hex"
+		if ext := filepath.Ext(*output); ext != "" {
+			*format = ext
+		}
+	}
+	write, err := paletteWriter(*format)
+	if err != nil {
+		return err
+	}
+	tree, err := octree.NewOctree(*depth)
+	if err != nil {
+		return err
+	}
+	for _, path := range flags.Args() {
+		img, err := loadImage(path)
+		if err != nil {
+			return err
+		}
+		addImage(tree, img)
+	}
+	warnSaturated(stderr, "palette", tree)
+	palette, err := tree.QuantizeWith(quantizer, *n, metric)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		return write(stdout, palette)
+	}
+	return writeFile(*output, func(w io.Writer) error {
+		return write(w, palette)
+	})
+}
+
+// Find the function that writes palettes in format, which is "json" or the
+// name or file extension of a palette format.
+func paletteWriter(format string) (func(io.Writer, []octree.PaletteColor) error, error) {
+	if format == "json" || format == ".json" {
+		return func(w io.Writer, palette []octree.PaletteColor) error {
+			data, err := json.MarshalIndent(palette, "", "  ")
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(w, "%s\n", data)
+			return err
+		}, nil
+	}
+	paletteFormat, err := octree.ParsePaletteFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	return func(w io.Writer, palette []octree.PaletteColor) error {
+		return octree.WritePalette(w, paletteFormat, palette)
+	}, nil
+}
+
+// Create path, and write its contents with write. The file is removed again
+// if write fails.
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"image/color"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/check.v1"
+)
+
+type PaletteSuite struct{}
+
+var _ = check.Suite(&PaletteSuite{})
+
+var (
+	red   = color.NRGBA{R: 0xFF, A: 0xFF}
+	green = color.NRGBA{G: 0xFF, A: 0xFF}
+	blue  = color.NRGBA{B: 0xFF, A: 0xFF}
+)
+
+func (*PaletteSuite) TestPaletteHex(c *check.C) {
+	dir := c.MkDir()
+	first := writePNG(c, dir, "first.png", stripedImage(red, red, green))
+	second := writePNG(c, dir, "second.png", stripedImage(blue))
+	status, stdout, stderr := runCommand("palette", "-n", "3", first, second)
+	c.Check(stderr, check.Equals, "")
+	c.Check(status, check.Equals, 0)
+	// Most common first
+	c.Check(stdout, check.Equals, "#ff0000\n#0000ff\n#00ff00\n")
+	status, stdout, _ = runCommand("palette", "-n", "1", "-depth", "3", first)
+	c.Check(status, check.Equals, 0)
+	c.Check(stdout, check.Equals, "#aa5500\n")
+}
+
+func (*PaletteSuite) TestPaletteQuantizer(c *check.C) {
+	dir := c.MkDir()
+	path := writePNG(c, dir, "image.png", stripedImage(red, red, green, blue))
+	for _, quantizer := range []string{"octree", "mediancut", "wu"} {
+		status, stdout, stderr := runCommand("palette", "-n", "3", "-quantizer", quantizer, path)
+		c.Check(stderr, check.Equals, "")
+		c.Check(status, check.Equals, 0)
+		c.Check(stdout, check.Equals, "#ff0000\n#0000ff\n#00ff00\n", check.Commentf(quantizer))
+	}
+}
+
+func (*PaletteSuite) TestPaletteJSON(c *check.C) {
+	dir := c.MkDir()
+	path := writePNG(c, dir, "image.png", stripedImage(red, green))
+	status, stdout, _ := runCommand("palette", "-format", "json", "-metric", "redmean", path)
+	// Equal counts are in Morton order
+	c.Check(status, check.Equals, 0)
+	c.Check(stdout, check.Equals, `[
+  {
+    "color": "#00ff00",
+    "count": 16
+  },
+  {
+    "color": "#ff0000",
+    "count": 16
+  }
+]
+`)
+}
+
+func (*PaletteSuite) TestPaletteOutputFile(c *check.C) {
+	dir := c.MkDir()
+	path := writePNG(c, dir, "image.png", stripedImage(red, green))
+	output := filepath.Join(dir, "palette.gpl")
+	status, stdout, _ := runCommand("palette", "-o", output, path)
+	c.Check(status, check.Equals, 0)
+	c.Check(stdout, check.Equals, "")
+	data, err := os.ReadFile(output)
+	c.Assert(err, check.IsNil)
+	c.Check(string(data), check.Equals, "GIMP Palette\n#\n  0 255   0\t#00ff00\n255   0   0\t#ff0000\n")
+}
+
+func (*PaletteSuite) TestPaletteErrors(c *check.C) {
+	dir := c.MkDir()
+	path := writePNG(c, dir, "image.png", stripedImage(red))
+	notImage := filepath.Join(dir, "notes.txt")
+	c.Assert(os.WriteFile(notImage, []byte("not an image"), 0o644), check.IsNil)
+	for _, test := range []struct {
+		args   []string
+		status int
+		stderr string
+	}{
+		{[]string{}, 2, `(?s)usage: octree palette \[flags\] image\.\.\..*`},
+		{[]string{"-bogus", path}, 2, `(?s)flag provided but not defined: -bogus.*`},
+		{[]string{"-metric", "cie2000", path}, 1, `octree palette: Unknown metric: "cie2000"\n`},
+		{[]string{"-quantizer", "neuquant", path}, 1, `octree palette: Unknown quantizer: "neuquant"\n`},
+		{[]string{"-format", "bmp", path}, 1, `octree palette: Unknown palette format: "bmp"\n`},
+		{[]string{"-depth", "12", path}, 1, `octree palette: Invalid octree depth: 12\n`},
+		{[]string{"-n", "0", path}, 1, `octree palette: Invalid palette size: 0\n`},
+		{[]string{notImage}, 1, `octree palette: .*notes.txt: image: unknown format\n`},
+		{[]string{filepath.Join(dir, "missing.png")}, 1, `octree palette: open .*missing.png: .*\n`},
+	} {
+		status, stdout, stderr := runCommand(append([]string{"palette"}, test.args...)...)
+		c.Check(status, check.Equals, test.status, check.Commentf("%v", test.args))
+		c.Check(stdout, check.Equals, "")
+		c.Check(stderr, check.Matches, test.stderr, check.Commentf("%v", test.args))
+	}
+}
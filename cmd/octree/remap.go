@@ -0,0 +1,280 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/jameinel/octree"
+)
+
+// Map every pixel of the images named on the command line, or found in the
+// directories named on it, to the nearest color of a palette.
+func runRemap(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("remap", "image-or-directory...", stderr)
+	palettePath := flags.String("palette", "", "the palette file to map the images onto (required)")
+	paletteFormat := flags.String("palette-format", "",
+		"the format of the palette file, hex, gpl, act, ase or paintnet;\n"+
+			"the default is from its extension")
+	depth := flags.Int("depth", 6, "the depth of the octree, 1 to 9")
+	dither := flags.Bool("dither", false, "spread the error of each pixel to its neighbors (Floyd-Steinberg)")
+	outDir := flags.String("o", "", "the directory to write the remapped images to (required)")
+	format := flags.String("format", "png", "the output format, png or gif")
+	jobs := flags.Int("j", runtime.NumCPU(), "how many images to remap at once")
+	if err := flags.Parse(args); err != nil {
+		return flag.ErrHelp
+	}
+	if flags.NArg() == 0 || *palettePath == "" || *outDir == "" {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+	if *format != "png" && *format != "gif" {
+		return fmt.Errorf("Unknown image format: %q", *format)
+	}
+	if *jobs < 1 {
+		return fmt.Errorf("Invalid number of jobs: %d", *jobs)
+	}
+	tree, err := loadPaletteFile(*palettePath, *paletteFormat, *depth)
+	if err != nil {
+		return err
+	}
+	inputs, err := imageFiles(flags.Args())
+	if err != nil {
+		return err
+	}
+	if len(inputs) == 0 {
+		return fmt.Errorf("No images found in %s", strings.Join(flags.Args(), ", "))
+	}
+	outputs, err := outputPaths(inputs, *outDir, *format)
+	if err != nil {
+		return err
+	}
+	r := remapper{tree: tree, dither: *dither, format: *format}
+	if *format == "gif" {
+		if r.gifPalette, err = gifPalette(tree); err != nil {
+			return err
+		}
+	}
+	results := make([]remapResult, len(inputs))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < min(*jobs, len(inputs)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = r.remapFile(inputs[i], outputs[i])
+			}
+		}()
+	}
+	for i := range inputs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	failed := 0
+	for i, result := range results {
+		if result.err != nil {
+			fmt.Fprintf(stderr, "octree remap: %v\n", result.err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(stdout, "%s: mean error %.2f, max error %.2f\n",
+			inputs[i], result.meanError, result.maxError)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d images failed", failed, len(inputs))
+	}
+	return nil
+}
+
+// Load a palette file into a tree, working out its format from its
+// extension if format is empty.
+func loadPaletteFile(path, format string, depth int) (*octree.Octree, error) {
+	if format == "" {
+		format = filepath.Ext(path)
+	}
+	paletteFormat, err := octree.ParsePaletteFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tree, err := octree.LoadPalette(f, paletteFormat, depth)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(tree.Palette()) == 0 {
+		return nil, fmt.Errorf("%s: palette has no colors", path)
+	}
+	return tree, nil
+}
+
+// Where each input is written to. Two inputs can't be written to the same
+// place, and nothing can be written over an input.
+func outputPaths(inputs []string, outDir, format string) ([]string, error) {
+	outputs := make([]string, len(inputs))
+	seen := make(map[string]string)
+	for _, input := range inputs {
+		abs, err := filepath.Abs(input)
+		if err != nil {
+			return nil, err
+		}
+		seen[abs] = input
+	}
+	for i, input := range inputs {
+		base := filepath.Base(input)
+		output := filepath.Join(outDir, strings.TrimSuffix(base, filepath.Ext(base))+"."+format)
+		abs, err := filepath.Abs(output)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := seen[abs]; ok {
+			return nil, fmt.Errorf("%s and %s would both be written to %s", other, input, output)
+		}
+		seen[abs] = input
+		outputs[i] = output
+	}
+	return outputs, nil
+}
+
+// The GIF palette for a tree, with a transparent color at the end.
+func gifPalette(tree *octree.Octree) (color.Palette, error) {
+	colors := tree.Palette()
+	if len(colors) > 255 {
+		return nil, fmt.Errorf("GIF images can't have %d colors, the most is 255", len(colors))
+	}
+	palette := make(color.Palette, 0, len(colors)+1)
+	for _, c := range colors {
+		palette = append(palette, color.NRGBA{R: c.R, G: c.G, B: c.B, A: 0xFF})
+	}
+	return append(palette, color.NRGBA{}), nil
+}
+
+type remapper struct {
+	tree       *octree.Octree
+	dither     bool
+	format     string
+	gifPalette color.Palette
+}
+
+type remapResult struct {
+	meanError float64
+	maxError  float64
+	err       error
+}
+
+func (r *remapper) remapFile(input, output string) remapResult {
+	img, err := loadImage(input)
+	if err != nil {
+		return remapResult{err: err}
+	}
+	remapped, meanError, maxError := r.remap(img)
+	err = writeFile(output, func(w io.Writer) error {
+		if r.format == "gif" {
+			return gif.Encode(w, toPaletted(remapped, r.gifPalette), nil)
+		}
+		return png.Encode(w, remapped)
+	})
+	if err != nil {
+		return remapResult{err: err}
+	}
+	return remapResult{meanError: meanError, maxError: maxError}
+}
+
+// Map each pixel of img to the closest color in the tree, keeping its alpha.
+// The error of a pixel is the distance from its color to the color it is
+// mapped to. Fully transparent pixels are left alone, and don't count.
+func (r *remapper) remap(img image.Image) (remapped *image.NRGBA, meanError, maxError float64) {
+	bounds := img.Bounds()
+	remapped = image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	// With dithering, the error that has been spread to this row and the
+	// next. They have a spare column at each end, so the edges need no
+	// special cases.
+	current := make([][3]float64, bounds.Dx()+2)
+	next := make([][3]float64, bounds.Dx()+2)
+	pixels := 0
+	totalError := 0.0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			red, green, blue, ok := octree.PixelRGB(img, x, y)
+			if !ok {
+				continue
+			}
+			original := [3]float64{float64(red), float64(green), float64(blue)}
+			target := original
+			col := x - bounds.Min.X + 1
+			if r.dither {
+				for i := range target {
+					target[i] = min(max(math.Round(target[i]+current[col][i]), 0), 255)
+				}
+			}
+			red, green, blue = r.tree.FindClosest(uint8(target[0]), uint8(target[1]), uint8(target[2])).RGB()
+			mapped := [3]float64{float64(red), float64(green), float64(blue)}
+			if r.dither {
+				for i := range target {
+					e := target[i] - mapped[i]
+					current[col+1][i] += e * 7 / 16
+					next[col-1][i] += e * 3 / 16
+					next[col][i] += e * 5 / 16
+					next[col+1][i] += e * 1 / 16
+				}
+			}
+			_, _, _, alpha := img.At(x, y).RGBA()
+			remapped.SetNRGBA(x-bounds.Min.X, y-bounds.Min.Y,
+				color.NRGBA{R: red, G: green, B: blue, A: uint8(alpha >> 8)})
+			dist2 := 0.0
+			for i := range original {
+				d := original[i] - mapped[i]
+				dist2 += d * d
+			}
+			dist := math.Sqrt(dist2)
+			totalError += dist
+			maxError = max(maxError, dist)
+			pixels++
+		}
+		current, next = next, current
+		clear(next)
+	}
+	if pixels > 0 {
+		meanError = totalError / float64(pixels)
+	}
+	return remapped, meanError, maxError
+}
+
+// Convert an image whose colors are all in palette to a paletted image. GIFs
+// can't be partly transparent, so pixels that are mostly transparent use the
+// transparent color at the end of the palette, and the rest are opaque.
+func toPaletted(img *image.NRGBA, palette color.Palette) *image.Paletted {
+	index := make(map[color.NRGBA]uint8, len(palette))
+	for i, c := range palette {
+		index[c.(color.NRGBA)] = uint8(i)
+	}
+	transparent := uint8(len(palette) - 1)
+	paletted := image.NewPaletted(img.Bounds(), palette)
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			c := img.NRGBAAt(x, y)
+			if c.A < 0x80 {
+				paletted.SetColorIndex(x, y, transparent)
+				continue
+			}
+			c.A = 0xFF
+			paletted.SetColorIndex(x, y, index[c])
+		}
+	}
+	return paletted
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/check.v1"
+)
+
+type RemapSuite struct{}
+
+var _ = check.Suite(&RemapSuite{})
+
+// Write a palette of black and white as a hex list, returning its path.
+func writeBlackWhitePalette(c *check.C, dir string) string {
+	path := filepath.Join(dir, "palette.hex")
+	c.Assert(os.WriteFile(path, []byte("#000000\n#ffffff\n"), 0o644), check.IsNil)
+	return path
+}
+
+func readImage(c *check.C, path string) image.Image {
+	f, err := os.Open(path)
+	c.Assert(err, check.IsNil)
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	c.Assert(err, check.IsNil)
+	return img
+}
+
+func (*RemapSuite) TestRemap(c *check.C) {
+	dir := c.MkDir()
+	palette := writeBlackWhitePalette(c, dir)
+	dark := color.NRGBA{R: 0x10, G: 0x10, B: 0x10, A: 0xFF}
+	light := color.NRGBA{R: 0xF0, G: 0xF0, B: 0xE0, A: 0x80}
+	input := writePNG(c, dir, "image.png", stripedImage(dark, light, color.NRGBA{}))
+	out := c.MkDir()
+	status, stdout, stderr := runCommand("remap", "-palette", palette, "-o", out, input)
+	c.Check(stderr, check.Equals, "")
+	c.Check(status, check.Equals, 0)
+	// sqrt(3 * 0x10^2) = 27.71, and sqrt(2 * 0x0F^2 + 0x1F^2) = 37.56
+	c.Check(stdout, check.Equals, input+": mean error 32.64, max error 37.56\n")
+	img := readImage(c, filepath.Join(out, "image.png"))
+	c.Check(color.NRGBAModel.Convert(img.At(0, 0)), check.Equals, color.NRGBA{A: 0xFF})
+	// The alpha is kept
+	c.Check(color.NRGBAModel.Convert(img.At(0, 4)), check.Equals,
+		color.NRGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0x80})
+	c.Check(color.NRGBAModel.Convert(img.At(0, 8)), check.Equals, color.NRGBA{})
+}
+
+func (*RemapSuite) TestRemapDither(c *check.C) {
+	dir := c.MkDir()
+	palette := writeBlackWhitePalette(c, dir)
+	grey := color.NRGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xFF}
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for i := 0; i < 16*16; i++ {
+		img.Set(i%16, i/16, grey)
+	}
+	input := writePNG(c, dir, "grey.png", img)
+	out := c.MkDir()
+	status, _, stderr := runCommand("remap", "-palette", palette, "-o", out, input)
+	c.Assert(status, check.Equals, 0, check.Commentf(stderr))
+	c.Check(countWhite(readImage(c, filepath.Join(out, "grey.png"))), check.Equals, 256)
+	status, _, stderr = runCommand("remap", "-palette", palette, "-o", out, "-dither", input)
+	c.Assert(status, check.Equals, 0, check.Commentf(stderr))
+	// About half of the pixels are white, to make grey on average
+	white := countWhite(readImage(c, filepath.Join(out, "grey.png")))
+	c.Check(white > 120 && white < 136, check.Equals, true, check.Commentf("%d", white))
+}
+
+func countWhite(img image.Image) int {
+	white := 0
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if r, _, _, _ := img.At(x, y).RGBA(); r == 0xFFFF {
+				white++
+			}
+		}
+	}
+	return white
+}
+
+func (*RemapSuite) TestRemapDirectoryToGIF(c *check.C) {
+	dir := c.MkDir()
+	palette := writeBlackWhitePalette(c, dir)
+	images := filepath.Join(dir, "images")
+	c.Assert(os.Mkdir(images, 0o755), check.IsNil)
+	var inputs []string
+	for _, name := range []string{"a.png", "b.png", "c.png", "d.png"} {
+		inputs = append(inputs, writePNG(c, images, name,
+			stripedImage(color.NRGBA{R: 0xFF, A: 0xFF}, color.NRGBA{})))
+	}
+	c.Assert(os.WriteFile(filepath.Join(images, "notes.txt"), nil, 0o644), check.IsNil)
+	out := c.MkDir()
+	status, stdout, stderr := runCommand("remap", "-palette", palette, "-o", out,
+		"-format", "gif", "-j", "3", images)
+	c.Check(stderr, check.Equals, "")
+	c.Check(status, check.Equals, 0)
+	// Results are in the order of the inputs, regardless of which finished
+	// first
+	expected := ""
+	for _, input := range inputs {
+		expected += input + ": mean error 255.00, max error 255.00\n"
+	}
+	c.Check(stdout, check.Equals, expected)
+	f, err := os.Open(filepath.Join(out, "c.gif"))
+	c.Assert(err, check.IsNil)
+	defer f.Close()
+	img, err := gif.Decode(f)
+	c.Assert(err, check.IsNil)
+	c.Check(img.At(0, 0), check.Equals, color.Color(color.RGBA{A: 0xFF}))
+	c.Check(img.At(0, 4), check.Equals, color.Color(color.RGBA{}))
+}
+
+func (*RemapSuite) TestRemapErrors(c *check.C) {
+	dir := c.MkDir()
+	palette := writeBlackWhitePalette(c, dir)
+	input := writePNG(c, dir, "image.png", stripedImage(color.NRGBA{A: 0xFF}))
+	other := c.MkDir()
+	c.Assert(os.Mkdir(filepath.Join(other, "image.png"), 0o755), check.IsNil)
+	otherInput := writePNG(c, filepath.Join(other, "image.png"), "image.png",
+		stripedImage(color.NRGBA{A: 0xFF}))
+	empty := filepath.Join(dir, "empty.gpl")
+	c.Assert(os.WriteFile(empty, []byte("GIMP Palette\n"), 0o644), check.IsNil)
+	broken := filepath.Join(dir, "broken.png")
+	c.Assert(os.WriteFile(broken, []byte("not a png"), 0o644), check.IsNil)
+	out := c.MkDir()
+	many := filepath.Join(dir, "many.hex")
+	var hex []byte
+	for i := 0; i < 256; i++ {
+		hex = fmt.Appendf(hex, "#0000%02x\n", i)
+	}
+	c.Assert(os.WriteFile(many, hex, 0o644), check.IsNil)
+	for _, test := range []struct {
+		args   []string
+		status int
+		stderr string
+	}{
+		{[]string{"-o", out, input}, 2, `(?s)usage: octree remap .*`},
+		{[]string{"-palette", palette, input}, 2, `(?s)usage: octree remap .*`},
+		{[]string{"-palette", palette, "-o", out, "-format", "bmp", input}, 1,
+			`octree remap: Unknown image format: "bmp"\n`},
+		{[]string{"-palette", palette, "-o", out, "-j", "0", input}, 1,
+			`octree remap: Invalid number of jobs: 0\n`},
+		{[]string{"-palette", empty, "-o", out, input}, 1,
+			`octree remap: .*empty.gpl: palette has no colors\n`},
+		{[]string{"-palette", palette, "-palette-format", "gpl", "-o", out, input}, 1,
+			`octree remap: .*palette.hex: Invalid GPL palette: .*\n`},
+		{[]string{"-palette", many, "-o", out, "-format", "gif", input}, 1,
+			`octree remap: GIF images can't have 256 colors, the most is 255\n`},
+		{[]string{"-palette", palette, "-o", out, out}, 1,
+			`octree remap: No images found in .*\n`},
+		{[]string{"-palette", palette, "-o", dir, input}, 1,
+			`octree remap: .*image.png and .*image.png would both be written to .*image.png\n`},
+		{[]string{"-palette", palette, "-o", out, input, otherInput}, 1,
+			`octree remap: .*image.png and .*image.png would both be written to .*image.png\n`},
+		{[]string{"-palette", palette, "-o", out, broken, input}, 1,
+			`octree remap: .*broken.png: image: unknown format\n` +
+				`octree remap: 1 of 2 images failed\n`},
+	} {
+		status, _, stderr := runCommand(append([]string{"remap"}, test.args...)...)
+		c.Check(status, check.Equals, test.status, check.Commentf("%v", test.args))
+		c.Check(stderr, check.Matches, test.stderr, check.Commentf("%v", test.args))
+	}
+	// The image that could be remapped still was
+	_, err := os.Stat(filepath.Join(out, "image.png"))
+	c.Check(err, check.IsNil)
+}
+
+func (*RemapSuite) TestToPaletted(c *check.C) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{R: 0xFF, A: 0x80})
+	img.SetNRGBA(1, 0, color.NRGBA{R: 0xFF, A: 0x7F})
+	palette := color.Palette{color.NRGBA{A: 0xFF}, color.NRGBA{R: 0xFF, A: 0xFF}, color.NRGBA{}}
+	paletted := toPaletted(img, palette)
+	c.Check(paletted.Pix, check.DeepEquals, []uint8{1, 2})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jameinel/octree"
+)
+
+// The report written by the stats command, which is also its JSON output.
+type statsReport struct {
+	Images         int                   `json:"images"`
+	Pixels         uint64                `json:"pixels"`
+	Counted        uint64                `json:"counted"`
+	DistinctColors int                   `json:"distinctColors"`
+	MostFrequent   []octree.PaletteColor `json:"mostFrequent"`
+	Layers         []layerReport         `json:"layers"`
+	LongestLeaves  []leafReport          `json:"longestLeaves"`
+}
+
+type layerReport struct {
+	Level    int    `json:"level"`
+	Occupied int    `json:"occupied"`
+	Blocks   int    `json:"blocks"`
+	MaxCount uint64 `json:"maxCount"`
+}
+
+type leafReport struct {
+	Level  int    `json:"level"`
+	Min    string `json:"min"`
+	Max    string `json:"max"`
+	Colors int    `json:"colors"`
+	Count  uint64 `json:"count"`
+}
+
+// Report on the colors used by all of the images named on the command line,
+// or found in the directories named on it.
+func runStats(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("stats", "image-or-directory...", stderr)
+	depth := flags.Int("depth", 6, "the depth of the octree, 1 to 9")
+	top := flags.Int("top", 10, "how many of the most frequent colors to list")
+	leaves := flags.Int("leaves", 5, "how many of the longest leaf buckets to list")
+	asJSON := flags.Bool("json", false, "write the report as JSON")
+	if err := flags.Parse(args); err != nil {
+		return flag.ErrHelp
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+	if *top < 0 || *leaves < 0 {
+		return fmt.Errorf("-top and -leaves can't be negative")
+	}
+	tree, err := octree.NewOctree(*depth)
+	if err != nil {
+		return err
+	}
+	inputs, err := imageFiles(flags.Args())
+	if err != nil {
+		return err
+	}
+	if len(inputs) == 0 {
+		return fmt.Errorf("No images found in %s", strings.Join(flags.Args(), ", "))
+	}
+	pixels := uint64(0)
+	for _, path := range inputs {
+		img, err := loadImage(path)
+		if err != nil {
+			return err
+		}
+		pixels += addImage(tree, img)
+	}
+	warnSaturated(stderr, "stats", tree)
+	report := newStatsReport(tree, len(inputs), pixels, *top, *leaves)
+	if *asJSON {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(stdout, "%s\n", data)
+		return err
+	}
+	report.write(stdout)
+	return nil
+}
+
+// The report on tree, built from images with pixels in them, including any
+// fully transparent pixels that weren't counted.
+func newStatsReport(tree *octree.Octree, images int, pixels uint64, top, leaves int) statsReport {
+	stats := tree.Stats(leaves)
+	report := statsReport{
+		Images:         images,
+		Pixels:         pixels,
+		Counted:        stats.Count,
+		DistinctColors: stats.Distinct,
+		MostFrequent:   []octree.PaletteColor{},
+		Layers:         []layerReport{},
+		LongestLeaves:  []leafReport{},
+	}
+	for _, v := range tree.MostFrequent(top) {
+		r, g, b := v.RGB()
+		report.MostFrequent = append(report.MostFrequent,
+			octree.PaletteColor{R: r, G: g, B: b, Count: v.Count()})
+	}
+	for _, layer := range stats.Layers {
+		report.Layers = append(report.Layers, layerReport(layer))
+	}
+	for _, leaf := range stats.LongestLeaves {
+		report.LongestLeaves = append(report.LongestLeaves, leafReport{
+			Level:  leaf.Level,
+			Min:    hexRGB(leaf.Min),
+			Max:    hexRGB(leaf.Max),
+			Colors: leaf.Distinct,
+			Count:  leaf.Count,
+		})
+	}
+	return report
+}
+
+func hexRGB(rgb [3]uint8) string {
+	return fmt.Sprintf("#%02x%02x%02x", rgb[0], rgb[1], rgb[2])
+}
+
+// What fraction of total part is, as a percentage.
+func percent(part, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(part) / float64(total)
+}
+
+func (r statsReport) write(w io.Writer) {
+	fmt.Fprintf(w, "images: %d\n", r.Images)
+	fmt.Fprintf(w, "pixels: %d\n", r.Pixels)
+	fmt.Fprintf(w, "counted: %d\n", r.Counted)
+	fmt.Fprintf(w, "distinct colors: %d\n", r.DistinctColors)
+	fmt.Fprintln(w, "most frequent colors:")
+	for _, p := range r.MostFrequent {
+		fmt.Fprintf(w, "  %s %10d %6.2f%%\n", hexRGB([3]uint8{p.R, p.G, p.B}), p.Count,
+			percent(p.Count, r.Counted))
+	}
+	fmt.Fprintln(w, "layer occupancy:")
+	for _, layer := range r.Layers {
+		fmt.Fprintf(w, "  level %d: %d of %d blocks (%.2f%%), largest count %d\n",
+			layer.Level, layer.Occupied, layer.Blocks,
+			percent(uint64(layer.Occupied), uint64(layer.Blocks)), layer.MaxCount)
+	}
+	fmt.Fprintln(w, "longest leaf buckets:")
+	for _, leaf := range r.LongestLeaves {
+		fmt.Fprintf(w, "  %s-%s (level %d): %d colors, count %d\n",
+			leaf.Min, leaf.Max, leaf.Level, leaf.Colors, leaf.Count)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"image/color"
+	"os"
+	"path/filepath"
+
+	"github.com/jameinel/octree"
+	"gopkg.in/check.v1"
+)
+
+type StatsSuite struct{}
+
+var _ = check.Suite(&StatsSuite{})
+
+func (*StatsSuite) TestStats(c *check.C) {
+	dir := c.MkDir()
+	writePNG(c, dir, "a.png", stripedImage(red, red, blue))
+	writePNG(c, dir, "b.png", stripedImage(red, color.NRGBA{R: 0xFE, A: 0xFF}, color.NRGBA{}))
+	status, stdout, stderr := runCommand("stats", "-depth", "3", "-top", "2", "-leaves", "1", dir)
+	c.Check(stderr, check.Equals, "")
+	c.Check(status, check.Equals, 0)
+	c.Check(stdout, check.Equals, `images: 2
+pixels: 96
+counted: 80
+distinct colors: 3
+most frequent colors:
+  #ff0000         48  60.00%
+  #0000ff         16  20.00%
+layer occupancy:
+  level 1: 2 of 8 blocks (25.00%), largest count 64
+  level 2: 2 of 64 blocks (3.12%), largest count 64
+longest leaf buckets:
+  #c00000-#ff3f3f (level 2): 2 colors, count 64
+`)
+}
+
+func (*StatsSuite) TestStatsJSON(c *check.C) {
+	dir := c.MkDir()
+	path := writePNG(c, dir, "a.png", stripedImage(green))
+	status, stdout, _ := runCommand("stats", "-json", "-depth", "2", path)
+	c.Check(status, check.Equals, 0)
+	var report statsReport
+	c.Assert(json.Unmarshal([]byte(stdout), &report), check.IsNil)
+	c.Check(report, check.DeepEquals, statsReport{
+		Images:         1,
+		Pixels:         16,
+		Counted:        16,
+		DistinctColors: 1,
+		MostFrequent:   []octree.PaletteColor{{G: 0xFF, Count: 16}},
+		Layers:         []layerReport{{Level: 1, Occupied: 1, Blocks: 8, MaxCount: 16}},
+		LongestLeaves:  []leafReport{{Level: 1, Min: "#008000", Max: "#7fff7f", Colors: 1, Count: 16}},
+	})
+}
+
+func (*StatsSuite) TestStatsErrors(c *check.C) {
+	dir := c.MkDir()
+	path := writePNG(c, dir, "a.png", stripedImage(green))
+	broken := filepath.Join(dir, "b.png")
+	c.Assert(os.WriteFile(broken, []byte("not a png"), 0o644), check.IsNil)
+	for _, test := range []struct {
+		args   []string
+		status int
+		stderr string
+	}{
+		{[]string{}, 2, `(?s)usage: octree stats .*`},
+		{[]string{"-top", "-1", path}, 1, `octree stats: -top and -leaves can't be negative\n`},
+		{[]string{"-depth", "0", path}, 1, `octree stats: Invalid octree depth: 0\n`},
+		{[]string{c.MkDir()}, 1, `octree stats: No images found in .*\n`},
+		{[]string{dir}, 1, `octree stats: .*b.png: image: unknown format\n`},
+	} {
+		status, stdout, stderr := runCommand(append([]string{"stats"}, test.args...)...)
+		c.Check(status, check.Equals, test.status, check.Commentf("%v", test.args))
+		c.Check(stdout, check.Equals, "")
+		c.Check(stderr, check.Matches, test.stderr, check.Commentf("%v", test.args))
+	}
+}
@@ -0,0 +1,154 @@
+package octree
+
+import (
+	"maps"
+	"math"
+	"slices"
+)
+
+const (
+	// What an Add counts for in a decaying tree, just after its counts have
+	// been rescaled. Counting more than 1 keeps the rounding of the weights
+	// of later adds small.
+	decayUnit = 1 << 8
+	// Once an Add would count for this much, everything is rescaled so that
+	// it counts for decayUnit again.
+	decayLimit = 1 << 16
+)
+
+func (o *Octree) setTimeOptions(opts octreeOptions) {
+	if opts.decay != 0 {
+		o.decay = opts.decay
+		o.decayWeight = decayUnit
+	}
+	if opts.window > 0 {
+		o.window = newFrameWindow(opts.window)
+	}
+}
+
+// Tick moves a tree built WithDecay or WithWindow on to the next frame, and
+// does nothing for other trees.
+//
+// A window removes the colors of the frame that falls out of it.
+//
+// Decay doesn't touch what has already been counted. Instead every Tick makes
+// later adds count for 1/factor times as much as earlier ones, which has the
+// same effect on every count relative to the others. Once adds count for too
+// much, every count is scaled back down in one pass that rebuilds the tree
+// from its colors. That pass costs O(distinct colors), and happens once every
+// log(256)/log(1/factor) ticks, such as every 8 ticks with a factor of 0.5;
+// the other ticks cost O(1). Colors whose counts round down to 0 are dropped
+// then. The layer counts are only ever changed along with the counts of the
+// colors, so they always agree.
+func (o *Octree) Tick() {
+	switch {
+	case o.decay != 0:
+		o.decayWeight /= o.decay
+		if o.decayWeight >= decayLimit {
+			o.rescale(decayUnit / o.decayWeight)
+			o.decayWeight = decayUnit
+		}
+	case o.window != nil:
+		dropped := o.window.tick()
+		for _, rgb := range slices.Sorted(maps.Keys(dropped)) {
+			o.removeCount(uint8(rgb>>16), uint8(rgb>>8), uint8(rgb), dropped[rgb])
+		}
+	}
+}
+
+// AddWeight is how much an Add counts for at the moment. It is always 1,
+// except for trees built WithDecay, where dividing a count by it gives the
+// count in units of adds made since the last Tick.
+func (o *Octree) AddWeight() float64 {
+	if o.decay != 0 {
+		return o.decayWeight
+	}
+	return 1
+}
+
+// The count that an Add adds to a decaying tree.
+func (o *Octree) decayedAdd() uint64 {
+	return uint64(math.Round(o.decayWeight))
+}
+
+// Multiply every count by scale, rounding down, and rebuild the tree from
+// the results.
+func (o *Octree) rescale(scale float64) {
+	var values []value
+	o.eachLeaf(o.rootCursor(), func(leaf []*value) {
+		for _, v := range leaf {
+			values = append(values, *v)
+		}
+	})
+	o.clearCounts()
+	for _, v := range values {
+		if count := uint64(float64(v.count) * scale); count > 0 {
+			o.addCount(v.r, v.g, v.b, count)
+		}
+	}
+}
+
+// Empty the tree, leaving its options alone.
+func (o *Octree) clearCounts() {
+	o.count = 0
+	for _, counts := range o.layerCounts {
+		clear(counts)
+	}
+	for _, counts := range o.layerCounts64 {
+		clear(counts)
+	}
+	for _, totals := range o.layerTotals {
+		clear(totals)
+	}
+	clear(o.values)
+	if o.root != nil {
+		o.root = o.newNode()
+	}
+}
+
+// The colors added during each of the frames of a window.
+type frameWindow struct {
+	size int
+	// Oldest first, so the current frame is last. Colors are packed as
+	// r<<16 | g<<8 | b.
+	frames []map[uint32]uint64
+}
+
+func newFrameWindow(size int) *frameWindow {
+	return &frameWindow{size: size, frames: []map[uint32]uint64{{}}}
+}
+
+func packRGB(r, g, b uint8) uint32 {
+	return uint32(r)<<16 | uint32(g)<<8 | uint32(b)
+}
+
+func (w *frameWindow) add(r, g, b uint8) {
+	w.frames[len(w.frames)-1][packRGB(r, g, b)]++
+}
+
+// Forget the most recent add of r,g,b.
+func (w *frameWindow) forget(r, g, b uint8) {
+	rgb := packRGB(r, g, b)
+	for i := len(w.frames) - 1; i >= 0; i-- {
+		if count := w.frames[i][rgb]; count > 0 {
+			if count == 1 {
+				delete(w.frames[i], rgb)
+			} else {
+				w.frames[i][rgb] = count - 1
+			}
+			return
+		}
+	}
+}
+
+// Start a new frame, returning the colors of the frame that no longer fits,
+// if there is one.
+func (w *frameWindow) tick() map[uint32]uint64 {
+	w.frames = append(w.frames, map[uint32]uint64{})
+	if len(w.frames) <= w.size {
+		return nil
+	}
+	dropped := w.frames[0]
+	w.frames = slices.Delete(w.frames, 0, 1)
+	return dropped
+}
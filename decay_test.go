@@ -0,0 +1,134 @@
+package octree
+
+import (
+	"math"
+
+	"gopkg.in/check.v1"
+)
+
+type DecaySuite struct{}
+
+var _ = check.Suite(&DecaySuite{})
+
+var decayStorage = [][]Option{
+	nil,
+	{WithStorage(SparseStorage)},
+	{WithAdaptiveLeaves(2)},
+	{WithNodeStats()},
+}
+
+func (*DecaySuite) TestInvalidOptions(c *check.C) {
+	_, err := NewOctree(3, WithDecay(1))
+	c.Check(err, check.ErrorMatches, "Invalid decay factor: 1")
+	_, err = NewOctree(3, WithDecay(-0.5))
+	c.Check(err, check.ErrorMatches, "Invalid decay factor: -0.5")
+	_, err = NewOctree(3, WithWindow(-1))
+	c.Check(err, check.ErrorMatches, "Invalid window size: -1")
+	_, err = NewOctree(3, WithDecay(0.5), WithWindow(2))
+	c.Check(err, check.ErrorMatches, "Decay and a window can't be used together")
+}
+
+// Check that every level of the tree adds up to the count of the root.
+func checkLayersAgree(c *check.C, oct *Octree) {
+	for level := 0; level < oct.depth; level++ {
+		total := uint64(0)
+		for n := range oct.Nodes(level) {
+			total += n.Count
+		}
+		if oct.maxLeafSize == 0 {
+			c.Check(total, check.Equals, oct.count, check.Commentf("level %d", level))
+		}
+	}
+	total := uint64(0)
+	for v := range oct.Entries() {
+		total += v.count
+	}
+	c.Check(total, check.Equals, oct.count)
+}
+
+func (*DecaySuite) TestDecay(c *check.C) {
+	for _, options := range decayStorage {
+		oct, err := NewOctree(3, append(options, WithDecay(0.5))...)
+		c.Assert(err, check.IsNil)
+		c.Check(oct.AddWeight(), check.Equals, 256.0)
+		// Decayed adds count for too much for 32 bits
+		c.Check(oct.maxCount, check.Equals, uint64(math.MaxUint64))
+		oct.Add(0xFF, 0, 0)
+		oct.Tick()
+		c.Check(oct.AddWeight(), check.Equals, 512.0)
+		oct.Add(0, 0, 0xFF)
+		oct.Add(0, 0, 0xFF)
+		c.Check(oct.FindClosest(0xFF, 0, 0).count, check.Equals, uint64(256))
+		c.Check(oct.FindClosest(0, 0, 0xFF).count, check.Equals, uint64(1024))
+		checkLayersAgree(c, oct)
+		// Adds would count for 65536 after 7 more ticks, so the counts are
+		// scaled back down by 256 instead
+		for i := 0; i < 7; i++ {
+			oct.Tick()
+		}
+		c.Check(oct.AddWeight(), check.Equals, 256.0)
+		c.Check(oct.FindClosest(0xFF, 0, 0).count, check.Equals, uint64(1))
+		c.Check(oct.FindClosest(0, 0, 0xFF).count, check.Equals, uint64(4))
+		c.Check(oct.count, check.Equals, uint64(5))
+		checkLayersAgree(c, oct)
+		// Another rescale rounds them all down to 0
+		for i := 0; i < 8; i++ {
+			oct.Tick()
+		}
+		c.Check(oct.count, check.Equals, uint64(0))
+		c.Check(oct.FindClosest(0, 0, 0), check.Equals, value{})
+		oct.Add(1, 2, 3)
+		c.Check(oct.count, check.Equals, uint64(256))
+		checkLayersAgree(c, oct)
+	}
+}
+
+func (*DecaySuite) TestDecayRemove(c *check.C) {
+	oct, err := NewOctree(3, WithDecay(0.5))
+	c.Assert(err, check.IsNil)
+	oct.Add(1, 2, 3)
+	// Only one count goes, not the weight of an Add
+	c.Check(oct.Remove(1, 2, 3), check.Equals, true)
+	c.Check(oct.FindClosest(1, 2, 3).count, check.Equals, uint64(255))
+	checkLayersAgree(c, oct)
+}
+
+func (*DecaySuite) TestWindow(c *check.C) {
+	for _, options := range decayStorage {
+		oct, err := NewOctree(3, append(options, WithWindow(2))...)
+		c.Assert(err, check.IsNil)
+		c.Check(oct.AddWeight(), check.Equals, 1.0)
+		oct.Add(0xFF, 0, 0)
+		oct.Add(0xFF, 0, 0)
+		oct.Add(0, 0xFF, 0)
+		oct.Tick()
+		oct.Add(0, 0, 0xFF)
+		oct.Add(0, 0xFF, 0)
+		c.Check(oct.count, check.Equals, uint64(5))
+		checkLayersAgree(c, oct)
+		// The first frame falls out of the window
+		oct.Tick()
+		c.Check(oct.count, check.Equals, uint64(2))
+		c.Check(oct.FindClosest(0xFF, 0, 0), check.Not(check.Equals),
+			value{r: 0xFF, count: 2})
+		c.Check(oct.FindClosest(0, 0xFF, 0).count, check.Equals, uint64(1))
+		checkLayersAgree(c, oct)
+		// Removing a color means its frame doesn't remove it again
+		oct.Add(0xFF, 0xFF, 0xFF)
+		c.Check(oct.Remove(0, 0xFF, 0), check.Equals, true)
+		oct.Tick()
+		c.Check(oct.count, check.Equals, uint64(1))
+		oct.Tick()
+		c.Check(oct.count, check.Equals, uint64(0))
+		checkLayersAgree(c, oct)
+	}
+}
+
+func (*DecaySuite) TestTickWithoutTime(c *check.C) {
+	oct, err := NewOctree(3)
+	c.Assert(err, check.IsNil)
+	oct.Add(1, 2, 3)
+	oct.Tick()
+	c.Check(oct.count, check.Equals, uint64(1))
+	c.Check(oct.AddWeight(), check.Equals, 1.0)
+}
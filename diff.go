@@ -0,0 +1,108 @@
+package octree
+
+import (
+	"iter"
+)
+
+// ColorChange is how the count of one color differs between two trees.
+type ColorChange struct {
+	R, G, B uint8
+	// The counts in the first and second tree, 0 if it isn't in one of them
+	Before, After uint64
+}
+
+// OctreeDiff lists how the colors of one tree differ from another, each in
+// Morton order.
+type OctreeDiff struct {
+	// Colors only in the second tree
+	Added []ColorChange
+	// Colors only in the first tree
+	Removed []ColorChange
+	// Colors in both, with different counts
+	Changed []ColorChange
+}
+
+// Diff compares the colors of a with those of b. The trees can have any
+// depth and options.
+//
+// The blocks of both trees are walked together in Morton order, down to the
+// deepest level they both have. A block that is empty in one tree has all of
+// the colors of the other listed straight away, without looking any further.
+// Otherwise the colors of both are merged at the bottom, so this is linear in
+// the number of colors. Blocks with the same count aren't skipped, as two
+// blocks can hold different colors that add up to the same count.
+func Diff(a, b *Octree) OctreeDiff {
+	var diff OctreeDiff
+	diff.block(a, b, 0, 0)
+	return diff
+}
+
+// Compare the block at level with the given Morton index in a and b.
+func (diff *OctreeDiff) block(a, b *Octree, level uint, index uint32) {
+	indexA, indexB := a.fromMorton(index, level), b.fromMorton(index, level)
+	countA, countB := a.countAt(level, indexA), b.countAt(level, indexB)
+	nodeA := Node{Level: int(level), Index: indexA}
+	nodeB := Node{Level: int(level), Index: indexB}
+	switch {
+	case countA == 0 && countB == 0:
+	case countB == 0:
+		for v := range a.NodeEntries(nodeA) {
+			diff.Removed = append(diff.Removed, ColorChange{R: v.r, G: v.g, B: v.b, Before: v.count})
+		}
+	case countA == 0:
+		for v := range b.NodeEntries(nodeB) {
+			diff.Added = append(diff.Added, ColorChange{R: v.r, G: v.g, B: v.b, After: v.count})
+		}
+	case int(level)+1 < min(a.depth, b.depth):
+		for child := uint32(0); child < 8; child++ {
+			diff.block(a, b, level+1, index<<3|child)
+		}
+	default:
+		diff.merge(a.NodeEntries(nodeA), b.NodeEntries(nodeB))
+	}
+}
+
+// Merge the colors of the same block of both trees, each in Morton order.
+func (diff *OctreeDiff) merge(entriesA, entriesB iter.Seq[value]) {
+	nextA, stopA := iter.Pull(entriesA)
+	defer stopA()
+	nextB, stopB := iter.Pull(entriesB)
+	defer stopB()
+	va, okA := nextA()
+	vb, okB := nextB()
+	for okA || okB {
+		var ka, kb uint32
+		if okA {
+			ka = interleaveRGB(va.r, va.g, va.b)
+		}
+		if okB {
+			kb = interleaveRGB(vb.r, vb.g, vb.b)
+		}
+		switch {
+		case okA && (!okB || ka < kb):
+			diff.Removed = append(diff.Removed, ColorChange{R: va.r, G: va.g, B: va.b, Before: va.count})
+			va, okA = nextA()
+		case okB && (!okA || kb < ka):
+			diff.Added = append(diff.Added, ColorChange{R: vb.r, G: vb.g, B: vb.b, After: vb.count})
+			vb, okB = nextB()
+		default:
+			if va.count != vb.count {
+				diff.Changed = append(diff.Changed, ColorChange{
+					R: va.r, G: va.g, B: va.b, Before: va.count, After: vb.count,
+				})
+			}
+			va, okA = nextA()
+			vb, okB = nextB()
+		}
+	}
+}
+
+// The index in the ordering of o of the block at level with the given Morton
+// index.
+func (o *Octree) fromMorton(index uint32, level uint) uint32 {
+	if o.ordering == MortonOrder {
+		return index
+	}
+	r, g, b := interleavedToRGB(index)
+	return o.blockIndex(r, g, b, level)
+}
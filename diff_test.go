@@ -0,0 +1,109 @@
+package octree
+
+import (
+	"maps"
+	"math/rand"
+	"slices"
+
+	"gopkg.in/check.v1"
+)
+
+type DiffSuite struct{}
+
+var _ = check.Suite(&DiffSuite{})
+
+func (*DiffSuite) TestDiff(c *check.C) {
+	for _, options := range [][]Option{
+		nil,
+		{WithOrdering(HilbertOrder)},
+		{WithAdaptiveLeaves(2)},
+	} {
+		a, err := NewOctree(3)
+		c.Assert(err, check.IsNil)
+		b, err := NewOctree(4, options...)
+		c.Assert(err, check.IsNil)
+		c.Check(Diff(a, b), check.DeepEquals, OctreeDiff{})
+		for _, rgb := range [][3]uint8{{0, 0, 0}, {0xFF, 0, 0}, {0xFF, 0, 0}, {0, 0, 0xFF}, {0x10, 0x10, 0x10}} {
+			a.Add(rgb[0], rgb[1], rgb[2])
+		}
+		for _, rgb := range [][3]uint8{{0, 0, 0}, {0xFF, 0, 0}, {0, 0xFF, 0}, {0, 0xFF, 0}, {0x10, 0x10, 0x10}, {0x10, 0x10, 0x10}} {
+			b.Add(rgb[0], rgb[1], rgb[2])
+		}
+		c.Check(Diff(a, b), check.DeepEquals, OctreeDiff{
+			Added:   []ColorChange{{G: 0xFF, After: 2}},
+			Removed: []ColorChange{{B: 0xFF, Before: 1}},
+			Changed: []ColorChange{
+				{R: 0x10, G: 0x10, B: 0x10, Before: 1, After: 2},
+				{R: 0xFF, Before: 2, After: 1},
+			},
+		})
+		c.Check(Diff(b, a), check.DeepEquals, OctreeDiff{
+			Added:   []ColorChange{{B: 0xFF, After: 1}},
+			Removed: []ColorChange{{G: 0xFF, Before: 2}},
+			Changed: []ColorChange{
+				{R: 0x10, G: 0x10, B: 0x10, Before: 2, After: 1},
+				{R: 0xFF, Before: 1, After: 2},
+			},
+		})
+		c.Check(Diff(a, a), check.DeepEquals, OctreeDiff{})
+	}
+}
+
+// Moving a count between colors in the same block leaves every block count
+// the same, which still has to show up.
+func (*DiffSuite) TestDiffSameCounts(c *check.C) {
+	a, err := NewOctree(3)
+	c.Assert(err, check.IsNil)
+	b, err := NewOctree(3)
+	c.Assert(err, check.IsNil)
+	a.Add(0, 0, 0)
+	b.Add(0, 0, 1)
+	c.Check(Diff(a, b), check.DeepEquals, OctreeDiff{
+		Added:   []ColorChange{{B: 1, After: 1}},
+		Removed: []ColorChange{{Before: 1}},
+	})
+}
+
+// Blocks that are empty in one tree are listed without merging, which has to
+// give the same as comparing every color.
+func (*DiffSuite) TestDiffEmptyBlocks(c *check.C) {
+	rng := rand.New(rand.NewSource(1))
+	for _, options := range [][]Option{
+		nil,
+		{WithOrdering(HilbertOrder)},
+		{WithAdaptiveLeaves(3)},
+	} {
+		a, err := NewOctree(4)
+		c.Assert(err, check.IsNil)
+		b, err := NewOctree(5, options...)
+		c.Assert(err, check.IsNil)
+		for i := 0; i < 300; i++ {
+			// Mostly dark in a and light in b, overlapping in the middle
+			a.Add(uint8(rng.Intn(160)), uint8(rng.Intn(160)), uint8(rng.Intn(4)))
+			b.Add(uint8(96+rng.Intn(160)), uint8(96+rng.Intn(160)), uint8(rng.Intn(4)))
+		}
+		counts := map[uint32][2]uint64{}
+		for v := range a.Entries() {
+			counts[interleaveRGB(v.r, v.g, v.b)] = [2]uint64{v.count, 0}
+		}
+		for v := range b.Entries() {
+			k := interleaveRGB(v.r, v.g, v.b)
+			counts[k] = [2]uint64{counts[k][0], v.count}
+		}
+		var expected OctreeDiff
+		for _, k := range slices.Sorted(maps.Keys(counts)) {
+			r, g, bl := interleavedToRGB(k)
+			change := ColorChange{R: r, G: g, B: bl, Before: counts[k][0], After: counts[k][1]}
+			switch {
+			case change.Before == 0:
+				expected.Added = append(expected.Added, change)
+			case change.After == 0:
+				expected.Removed = append(expected.Removed, change)
+			case change.Before != change.After:
+				expected.Changed = append(expected.Changed, change)
+			}
+		}
+		c.Check(expected.Added, check.Not(check.HasLen), 0)
+		c.Check(Diff(a, b), check.DeepEquals, expected)
+	}
+}
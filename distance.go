@@ -0,0 +1,163 @@
+package octree
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+)
+
+// Distance selects how HistogramDistance compares two color distributions.
+// All of them are 0 for identical distributions.
+type Distance int
+
+const (
+	// IntersectionDistance is 1 minus the histogram intersection, the
+	// fraction of the colors that the two have in common. It is 1 when they
+	// have no blocks in common.
+	IntersectionDistance Distance = iota
+	// ChiSquaredDistance is half the sum of (p-q)^2/(p+q) over the blocks,
+	// which is between 0 and 1. It weighs differences in rare blocks more
+	// heavily than IntersectionDistance does.
+	ChiSquaredDistance
+	// BhattacharyyaDistance is sqrt(1 - BC), where BC is the Bhattacharyya
+	// coefficient, the sum of sqrt(p*q) over the blocks. It is between 0
+	// and 1.
+	BhattacharyyaDistance
+	// EMDDistance approximates the Earth Mover's Distance, how far the colors
+	// of one have to move to turn it into the other, in units of a step of
+	// one channel. The fraction of the colors that can't be matched up within
+	// the blocks of each level from 1 down to the level compared is charged
+	// the size of a block at that level. The blocks don't move, so colors
+	// just either side of a block boundary are overcharged: all of the colors
+	// at 127 against all of them at 128 costs 128+64+... rather than 1. Level
+	// 0 has no levels to charge for, so it is always 0.
+	EMDDistance
+)
+
+func (d Distance) String() string {
+	switch d {
+	case IntersectionDistance:
+		return "intersection"
+	case ChiSquaredDistance:
+		return "chisquared"
+	case BhattacharyyaDistance:
+		return "bhattacharyya"
+	case EMDDistance:
+		return "emd"
+	}
+	return fmt.Sprintf("Distance(%d)", int(d))
+}
+
+// ParseDistance finds the Distance with the given name, as returned by
+// String.
+func ParseDistance(name string) (Distance, error) {
+	switch strings.ToLower(name) {
+	case "intersection":
+		return IntersectionDistance, nil
+	case "chisquared":
+		return ChiSquaredDistance, nil
+	case "bhattacharyya":
+		return BhattacharyyaDistance, nil
+	case "emd":
+		return EMDDistance, nil
+	}
+	return 0, fmt.Errorf("Unknown distance: %q", name)
+}
+
+// HistogramDistance compares the color distributions of two trees of the same
+// depth, using the counts of their blocks at level, which may be anything
+// from 0 (the root) to Depth()-1. Coarser levels have fewer blocks to
+// compare, so they are cheaper. The counts are normalized first, so trees
+// holding different numbers of colors can be compared.
+func HistogramDistance(a, b *Octree, level int, distance Distance) (float64, error) {
+	if a.depth != b.depth {
+		return 0, fmt.Errorf("Octrees have different depths: %d and %d", a.depth, b.depth)
+	}
+	if level < 0 || level >= a.depth {
+		return 0, fmt.Errorf("Invalid level: %d", level)
+	}
+	if distance < IntersectionDistance || distance > EMDDistance {
+		return 0, fmt.Errorf("Invalid distance: %d", distance)
+	}
+	if a.count == 0 || b.count == 0 {
+		return 0, fmt.Errorf("Can't compare the distribution of an empty octree")
+	}
+	if distance == EMDDistance {
+		emd := 0.0
+		for l := 1; l <= level; l++ {
+			p, q := a.levelHistogram(uint(l)), b.levelHistogram(uint(l))
+			blockSize := 256 >> l
+			emd += float64(blockSize) * histogramL1(p, q, a.count, b.count) / 2
+		}
+		return emd, nil
+	}
+	p, q := a.levelHistogram(uint(level)), b.levelHistogram(uint(level))
+	pTotal, qTotal := float64(a.count), float64(b.count)
+	sum := 0.0
+	eachBlock(p, q, func(pCount, qCount uint64) {
+		pi, qi := float64(pCount)/pTotal, float64(qCount)/qTotal
+		switch distance {
+		case IntersectionDistance:
+			sum += min(pi, qi)
+		case ChiSquaredDistance:
+			sum += (pi - qi) * (pi - qi) / (pi + qi)
+		case BhattacharyyaDistance:
+			sum += math.Sqrt(pi * qi)
+		}
+	})
+	switch distance {
+	case IntersectionDistance:
+		return max(1-sum, 0), nil
+	case ChiSquaredDistance:
+		return sum / 2, nil
+	}
+	return math.Sqrt(max(1-sum, 0)), nil
+}
+
+// The sum of the differences between the normalized counts of p and q.
+func histogramL1(p, q map[uint32]uint64, pTotal, qTotal uint64) float64 {
+	sum := 0.0
+	eachBlock(p, q, func(pCount, qCount uint64) {
+		sum += math.Abs(float64(pCount)/float64(pTotal) - float64(qCount)/float64(qTotal))
+	})
+	return sum
+}
+
+// Call f with the counts of every block that is in either p or q, in order
+// of their keys, so that the sums come out the same every time.
+func eachBlock(p, q map[uint32]uint64, f func(pCount, qCount uint64)) {
+	blocks := slices.AppendSeq(slices.Collect(maps.Keys(p)), maps.Keys(q))
+	slices.Sort(blocks)
+	for _, block := range slices.Compact(blocks) {
+		f(p[block], q[block])
+	}
+}
+
+// The counts of the non-empty blocks at level, keyed by the Morton index of
+// the block, so that trees with different orderings can be compared. Blocks
+// inside an adaptive leaf that is above level are counted from its values.
+func (o *Octree) levelHistogram(level uint) map[uint32]uint64 {
+	hist := make(map[uint32]uint64)
+	var walk func(c cursor)
+	walk = func(c cursor) {
+		if c.level == level {
+			r, g, b := o.blockCoords(c.index, c.level)
+			hist[interleaveRGB(r, g, b)] += o.cursorCount(c)
+			return
+		}
+		if o.cursorIsLeaf(c) {
+			for _, v := range o.cursorValues(c) {
+				hist[interleaveRGB(v.r, v.g, v.b)>>(24-3*level)] += v.count
+			}
+			return
+		}
+		var buf [8]cursor
+		for _, child := range o.appendChildren(buf[:0], c) {
+			walk(child)
+		}
+	}
+	walk(o.rootCursor())
+	return hist
+}
@@ -0,0 +1,129 @@
+package octree
+
+import (
+	"math"
+	"math/rand"
+
+	"gopkg.in/check.v1"
+)
+
+type DistanceSuite struct{}
+
+var _ = check.Suite(&DistanceSuite{})
+
+var allDistances = []Distance{
+	IntersectionDistance, ChiSquaredDistance, BhattacharyyaDistance, EMDDistance,
+}
+
+func (*DistanceSuite) TestParseDistance(c *check.C) {
+	for _, d := range allDistances {
+		parsed, err := ParseDistance(d.String())
+		c.Check(err, check.IsNil)
+		c.Check(parsed, check.Equals, d)
+	}
+	_, err := ParseDistance("manhattan")
+	c.Check(err, check.ErrorMatches, `Unknown distance: "manhattan"`)
+}
+
+func distanceTree(c *check.C, colors [][3]uint8, options ...Option) *Octree {
+	oct, err := NewOctree(3, options...)
+	c.Assert(err, check.IsNil)
+	for _, rgb := range colors {
+		oct.Add(rgb[0], rgb[1], rgb[2])
+	}
+	return oct
+}
+
+func checkDistances(c *check.C, a, b *Octree, level int, expected []float64) {
+	for i, d := range allDistances {
+		dist, err := HistogramDistance(a, b, level, d)
+		c.Assert(err, check.IsNil)
+		c.Check(math.Abs(dist-expected[i]) < 1e-9, check.Equals, true,
+			check.Commentf("%s at level %d: %v, not %v", d, level, dist, expected[i]))
+	}
+}
+
+func (*DistanceSuite) TestHistogramDistance(c *check.C) {
+	black := [3]uint8{0, 0, 0}
+	white := [3]uint8{0xFF, 0xFF, 0xFF}
+	blacks := distanceTree(c, [][3]uint8{black, black})
+	whites := distanceTree(c, [][3]uint8{white})
+	mixed := distanceTree(c, [][3]uint8{black, white})
+	checkDistances(c, blacks, blacks, 2, []float64{0, 0, 0, 0})
+	checkDistances(c, blacks, whites, 2, []float64{1, 1, 1, 128 + 64})
+	checkDistances(c, blacks, whites, 0, []float64{0, 0, 0, 0})
+	checkDistances(c, blacks, mixed, 2, []float64{
+		0.5,
+		(0.25/1.5 + 0.25/0.5) / 2,
+		math.Sqrt(1 - math.Sqrt(0.5)),
+		(128 + 64) * 0.5,
+	})
+	// Neighbouring colors either side of a block boundary are charged for
+	// every level they are split at
+	low := distanceTree(c, [][3]uint8{{0x7F, 0, 0}})
+	high := distanceTree(c, [][3]uint8{{0x80, 0, 0}})
+	checkDistances(c, low, high, 2, []float64{1, 1, 1, 128 + 64})
+	checkDistances(c, low, high, 0, []float64{0, 0, 0, 0})
+	// Colors in the same block can't be told apart at that level
+	grey := distanceTree(c, [][3]uint8{{0x10, 0x10, 0x10}})
+	checkDistances(c, blacks, grey, 2, []float64{0, 0, 0, 0})
+	checkDistances(c, blacks, grey, 1, []float64{0, 0, 0, 0})
+}
+
+func (*DistanceSuite) TestHistogramDistanceOptions(c *check.C) {
+	rng := rand.New(rand.NewSource(1))
+	var aColors, bColors [][3]uint8
+	for i := 0; i < 200; i++ {
+		aColors = append(aColors, [3]uint8{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(128))})
+		bColors = append(bColors, [3]uint8{uint8(rng.Intn(256)), uint8(rng.Intn(128)), uint8(rng.Intn(256))})
+	}
+	a := distanceTree(c, aColors)
+	b := distanceTree(c, bColors)
+	var expected [3][]float64
+	for level := range expected {
+		for _, d := range allDistances {
+			dist, err := HistogramDistance(a, b, level, d)
+			c.Assert(err, check.IsNil)
+			expected[level] = append(expected[level], dist)
+		}
+	}
+	c.Check(expected[2][0] > 0, check.Equals, true)
+	// The sums are taken in the same order every time, so they are exact
+	for i := 0; i < 10; i++ {
+		for _, d := range allDistances {
+			dist, err := HistogramDistance(a, b, 2, d)
+			c.Assert(err, check.IsNil)
+			c.Check(dist, check.Equals, expected[2][d])
+		}
+	}
+	// The layout of the blocks makes no difference
+	for _, options := range [][]Option{
+		{WithOrdering(HilbertOrder)},
+		{WithStorage(SparseStorage)},
+		{WithAdaptiveLeaves(4)},
+		{WithAdaptiveLeaves(4), WithOrdering(HilbertOrder)},
+	} {
+		other := distanceTree(c, bColors, options...)
+		for level := range expected {
+			checkDistances(c, a, other, level, expected[level])
+		}
+	}
+}
+
+func (*DistanceSuite) TestHistogramDistanceErrors(c *check.C) {
+	a := distanceTree(c, [][3]uint8{{1, 2, 3}})
+	empty := distanceTree(c, nil)
+	deeper, err := NewOctree(4)
+	c.Assert(err, check.IsNil)
+	deeper.Add(1, 2, 3)
+	_, err = HistogramDistance(a, deeper, 1, IntersectionDistance)
+	c.Check(err, check.ErrorMatches, "Octrees have different depths: 3 and 4")
+	_, err = HistogramDistance(a, a, 3, IntersectionDistance)
+	c.Check(err, check.ErrorMatches, "Invalid level: 3")
+	_, err = HistogramDistance(a, a, -1, IntersectionDistance)
+	c.Check(err, check.ErrorMatches, "Invalid level: -1")
+	_, err = HistogramDistance(a, a, 1, Distance(9))
+	c.Check(err, check.ErrorMatches, "Invalid distance: 9")
+	_, err = HistogramDistance(a, empty, 1, IntersectionDistance)
+	c.Check(err, check.ErrorMatches, "Can't compare the distribution of an empty octree")
+}
@@ -0,0 +1,101 @@
+package octree
+
+import (
+	"cmp"
+	"container/heap"
+	"slices"
+)
+
+// MostFrequent finds the n values with the highest counts, highest first.
+// Values with the same count are in Morton order. Blocks are visited largest
+// count first, and any block whose count is below the nth best count found so
+// far is skipped, as nothing inside it could make the cut.
+func (o *Octree) MostFrequent(n int) []value {
+	if n <= 0 {
+		return nil
+	}
+	s := frequentSearch{o: o, n: n}
+	s.search(o.rootCursor())
+	best := []*value(s.best)
+	slices.SortFunc(best, func(x, y *value) int {
+		return -compareFrequency(x, y)
+	})
+	found := make([]value, len(best))
+	for i, v := range best {
+		found[i] = *v
+	}
+	return found
+}
+
+// Compare how frequent two values are. A higher count is more frequent, and
+// for the same count, a lower Morton index is.
+func compareFrequency(x, y *value) int {
+	if x.count != y.count {
+		if x.count < y.count {
+			return -1
+		}
+		return 1
+	}
+	return int(interleaveRGB(y.r, y.g, y.b)) - int(interleaveRGB(x.r, x.g, x.b))
+}
+
+type frequentSearch struct {
+	o    *Octree
+	n    int
+	best frequentHeap
+}
+
+func (s *frequentSearch) search(c cursor) {
+	if s.o.cursorIsLeaf(c) {
+		for _, v := range s.o.cursorValues(c) {
+			if len(s.best) < s.n {
+				heap.Push(&s.best, v)
+			} else if compareFrequency(v, s.best[0]) > 0 {
+				s.best[0] = v
+				heap.Fix(&s.best, 0)
+			}
+		}
+		return
+	}
+	var buf [8]cursor
+	children := s.o.appendChildren(buf[:0], c)
+	slices.SortStableFunc(children, func(x, y cursor) int {
+		return cmp.Compare(s.o.cursorCount(y), s.o.cursorCount(x))
+	})
+	for _, child := range children {
+		if len(s.best) == s.n {
+			// No value can have a higher count than its block, or a lower
+			// Morton index than the lowest corner of its block
+			worst := s.best[0]
+			count := s.o.cursorCount(child)
+			if count < worst.count {
+				// Children are sorted by count, so the rest are no better
+				break
+			}
+			vMin, _ := s.o.cursorMinMax(child)
+			if count == worst.count &&
+				interleaveRGB(vMin.r, vMin.g, vMin.b) > interleaveRGB(worst.r, worst.g, worst.b) {
+				continue
+			}
+		}
+		s.search(child)
+	}
+}
+
+// A min-heap of values, least frequent first, implementing heap.Interface.
+type frequentHeap []*value
+
+func (h frequentHeap) Len() int { return len(h) }
+
+func (h frequentHeap) Less(i, j int) bool { return compareFrequency(h[i], h[j]) < 0 }
+
+func (h frequentHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *frequentHeap) Push(x any) { *h = append(*h, x.(*value)) }
+
+func (h *frequentHeap) Pop() any {
+	old := *h
+	v := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return v
+}
@@ -0,0 +1,66 @@
+package octree
+
+import (
+	"math/rand"
+	"slices"
+
+	"gopkg.in/check.v1"
+)
+
+type FrequentSuite struct{}
+
+var _ = check.Suite(&FrequentSuite{})
+
+func (*FrequentSuite) TestMostFrequent(c *check.C) {
+	oct, err := NewOctree(3)
+	c.Assert(err, check.IsNil)
+	c.Check(oct.MostFrequent(3), check.HasLen, 0)
+	for i := 0; i < 3; i++ {
+		oct.Add(0xFF, 0x00, 0x00)
+	}
+	oct.Add(0x00, 0xFF, 0x00)
+	oct.Add(0x00, 0xFF, 0x00)
+	oct.Add(0x00, 0x00, 0xFF)
+	oct.Add(0x00, 0x00, 0x01)
+	c.Check(oct.MostFrequent(2), check.DeepEquals, []value{
+		{r: 0xFF, count: 3},
+		{g: 0xFF, count: 2},
+	})
+	// Ties are broken by Morton index
+	c.Check(oct.MostFrequent(4), check.DeepEquals, []value{
+		{r: 0xFF, count: 3},
+		{g: 0xFF, count: 2},
+		{b: 0x01, count: 1},
+		{b: 0xFF, count: 1},
+	})
+	c.Check(oct.MostFrequent(10), check.HasLen, 4)
+	c.Check(oct.MostFrequent(0), check.HasLen, 0)
+	c.Check(oct.MostFrequent(-1), check.HasLen, 0)
+}
+
+func (*FrequentSuite) TestMostFrequentMatchesSort(c *check.C) {
+	rng := rand.New(rand.NewSource(1))
+	for _, options := range [][]Option{
+		nil,
+		{WithOrdering(HilbertOrder)},
+		{WithStorage(SparseStorage)},
+		{WithAdaptiveLeaves(4)},
+	} {
+		oct, err := NewOctree(5, options...)
+		c.Assert(err, check.IsNil)
+		for i := 0; i < 3000; i++ {
+			// Few enough colors that there are lots of ties
+			oct.Add(uint8(rng.Intn(8)*32), uint8(rng.Intn(8)*32), uint8(rng.Intn(8)*32))
+		}
+		var all []value
+		for _, v := range oct.sortedValues() {
+			all = append(all, *v)
+		}
+		slices.SortStableFunc(all, func(x, y value) int {
+			return int(int64(y.count) - int64(x.count))
+		})
+		for _, n := range []int{1, 5, 20, 100, 1000} {
+			c.Check(oct.MostFrequent(n), check.DeepEquals, all[:min(n, len(all))])
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package octree
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+// Track the counts of RGBA colors in a 16-way structure. This is the same
+// layout as Octree, with alpha added as a 4th dimension, so every layer has
+// 16 times as many blocks as the one above it.
+type Hextree struct {
+	count uint32
+	// Each layer has 16^n count fields
+	layerCounts [][]uint32
+	// The last layer maps to a sparse slice of values.
+	values [][]*rgbaValue
+	// How much a difference in alpha matters compared to the same difference
+	// in r, g or b. 0 ignores alpha entirely when finding the closest value.
+	alphaWeight float64
+}
+
+type rgbaValue struct {
+	r, g, b, a uint8
+	count      uint32
+}
+
+// The color of a value returned by a search.
+func (v rgbaValue) RGBA() (r, g, b, a uint8) {
+	return v.r, v.g, v.b, v.a
+}
+
+// How many times the color was added.
+func (v rgbaValue) Count() uint64 {
+	return uint64(v.count)
+}
+
+// Create a new Hextree. Because each layer is 16x bigger than the last, depth
+// is limited to 5 (65536 leaf blocks, each 16 wide).
+func NewHextree(depth int) (*Hextree, error) {
+	if depth < 1 || depth > 5 {
+		return nil, fmt.Errorf("Invalid hextree depth: %d", depth)
+	}
+	layers := make([][]uint32, depth-1)
+	size := 1
+	for i := range layers {
+		size *= 16
+		layers[i] = make([]uint32, size)
+	}
+	values := make([][]*rgbaValue, size)
+	return &Hextree{
+		layerCounts: layers,
+		values:      values,
+		alphaWeight: 1,
+	}, nil
+}
+
+// Set how much a difference in alpha matters to FindClosest, relative to the
+// same difference in r, g or b. The default is 1.
+func (h *Hextree) SetAlphaWeight(weight float64) error {
+	if weight < 0 || math.IsNaN(weight) || math.IsInf(weight, 0) {
+		return fmt.Errorf("Invalid alpha weight: %v", weight)
+	}
+	h.alphaWeight = weight
+	return nil
+}
+
+func (h *Hextree) Add(r, g, b, a uint8) {
+	h.count++
+	index := interleaveRGBA(r, g, b, a)
+	for depth, counts := range h.layerCounts {
+		layerIndex := index >> uint(28-depth*4)
+		counts[layerIndex]++
+	}
+	vi := h.blockIndex(index)
+	valueSlice := h.values[vi]
+	for _, v := range valueSlice {
+		if r == v.r && g == v.g && b == v.b && a == v.a {
+			v.count++
+			return
+		}
+	}
+	v := &rgbaValue{r: r, g: g, b: b, a: a, count: 1}
+	h.values[vi] = append(valueSlice, v)
+}
+
+// Find the index of the leaf block that holds a given interleaved index.
+func (h *Hextree) blockIndex(index uint32) uint32 {
+	// Go defines shifting by >= 32 as 0, which is what we want for a
+	// single block.
+	return index >> uint(32-len(h.layerCounts)*4)
+}
+
+// The weighted distance^2 to a given value
+func (h *Hextree) dist2ToV(r, g, b, a uint8, v *rgbaValue) float64 {
+	dr := float64(v.r) - float64(r)
+	dg := float64(v.g) - float64(g)
+	db := float64(v.b) - float64(b)
+	da := float64(v.a) - float64(a)
+	return dr*dr + dg*dg + db*db + h.alphaWeight*da*da
+}
+
+// The weighted distance^2 from r,g,b,a to the nearest point of the block
+// 'level' layers below the root. This is 0 if the point is inside the block.
+func (h *Hextree) dist2ToBlock(r, g, b, a uint8, level int, bindex uint32) float64 {
+	index := bindex << uint(32-level*4)
+	rMin, gMin, bMin, aMin := interleavedToRGBA(index)
+	stride := uint8(0xFF) >> uint(level)
+	d := axisDist(r, rMin, stride)
+	dist2 := d * d
+	d = axisDist(g, gMin, stride)
+	dist2 += d * d
+	d = axisDist(b, bMin, stride)
+	dist2 += d * d
+	d = axisDist(a, aMin, stride)
+	dist2 += h.alphaWeight * d * d
+	return dist2
+}
+
+// How far v is outside of the range [vMin, vMin+stride]
+func axisDist(v, vMin, stride uint8) float64 {
+	if v < vMin {
+		return float64(vMin - v)
+	}
+	if v-vMin > stride {
+		return float64(v - vMin - stride)
+	}
+	return 0
+}
+
+// Find the stored value that is closest to r,g,b,a. Differences in alpha are
+// scaled by the alpha weight.
+func (h *Hextree) FindClosest(r, g, b, a uint8) rgbaValue {
+	search := hextreeSearch{h: h, r: r, g: g, b: b, a: a, bestDist2: math.Inf(1)}
+	search.searchBlock(0, 0)
+	if search.best == nil {
+		return rgbaValue{}
+	}
+	return *search.best
+}
+
+type hextreeSearch struct {
+	h          *Hextree
+	r, g, b, a uint8
+	best       *rgbaValue
+	bestDist2  float64
+}
+
+// Walk down from a block, visiting the children closest to the target first,
+// and skipping any that are empty or further away than the best match so far.
+func (s *hextreeSearch) searchBlock(level int, bindex uint32) {
+	if level == len(s.h.layerCounts) {
+		for _, v := range s.h.values[bindex] {
+			dist2 := s.h.dist2ToV(s.r, s.g, s.b, s.a, v)
+			if s.best == nil || dist2 < s.bestDist2 {
+				s.best = v
+				s.bestDist2 = dist2
+			}
+		}
+		return
+	}
+	type child struct {
+		index uint32
+		dist2 float64
+	}
+	children := make([]child, 0, 16)
+	counts := s.h.layerCounts[level]
+	for i := uint32(0); i < 16; i++ {
+		index := bindex<<4 | i
+		if counts[index] == 0 {
+			continue
+		}
+		dist2 := s.h.dist2ToBlock(s.r, s.g, s.b, s.a, level+1, index)
+		children = append(children, child{index: index, dist2: dist2})
+	}
+	slices.SortFunc(children, func(x, y child) int {
+		if x.dist2 < y.dist2 {
+			return -1
+		}
+		if x.dist2 > y.dist2 {
+			return 1
+		}
+		return 0
+	})
+	for _, c := range children {
+		if s.best != nil && c.dist2 >= s.bestDist2 {
+			// Everything else is even further away
+			return
+		}
+		s.searchBlock(level+1, c.index)
+	}
+}
+
+// Like morton256_3D, but spreading each bit out to every 4th bit.
+var morton256_4D = func() []uint32 {
+	table := make([]uint32, 256)
+	for x := range table {
+		for bit := uint(0); bit < 8; bit++ {
+			table[x] |= uint32(x>>bit&1) << (4 * bit)
+		}
+	}
+	return table
+}()
+
+// The 4D version of interleaveRGB. r ends up in the highest bit of each group
+// of 4, and a in the lowest.
+func interleaveRGBA(r, g, b, a uint8) uint32 {
+	return morton256_4D[a] + morton256_4D[b]<<1 + morton256_4D[g]<<2 + morton256_4D[r]<<3
+}
+
+// This inverts the effect of interleaveRGBA.
+func interleavedToRGBA(index uint32) (r, g, b, a uint8) {
+	for bit := uint(0); bit < 8; bit++ {
+		a |= uint8(index&0x1) << bit
+		index >>= 1
+		b |= uint8(index&0x1) << bit
+		index >>= 1
+		g |= uint8(index&0x1) << bit
+		index >>= 1
+		r |= uint8(index&0x1) << bit
+		index >>= 1
+	}
+	return
+}
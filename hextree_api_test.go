@@ -0,0 +1,23 @@
+package octree_test
+
+import (
+	"github.com/jameinel/octree"
+	"gopkg.in/check.v1"
+)
+
+// Searches have to be usable from outside the package.
+type HextreeAPISuite struct{}
+
+var _ = check.Suite(&HextreeAPISuite{})
+
+func (*HextreeAPISuite) TestFindClosest(c *check.C) {
+	hex, err := octree.NewHextree(3)
+	c.Assert(err, check.IsNil)
+	hex.Add(0x10, 0x20, 0x30, 0x40)
+	hex.Add(0x10, 0x20, 0x30, 0x40)
+	hex.Add(0xF0, 0xF0, 0xF0, 0xFF)
+	v := hex.FindClosest(0x12, 0x20, 0x30, 0x48)
+	r, g, b, a := v.RGBA()
+	c.Check([]uint8{r, g, b, a}, check.DeepEquals, []uint8{0x10, 0x20, 0x30, 0x40})
+	c.Check(v.Count(), check.Equals, uint64(2))
+}
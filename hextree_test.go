@@ -0,0 +1,124 @@
+package octree
+
+import (
+	"math"
+	"math/rand"
+
+	"gopkg.in/check.v1"
+)
+
+type HextreeSuite struct{}
+
+var _ = check.Suite(&HextreeSuite{})
+
+func (*HextreeSuite) TestNewHextree(c *check.C) {
+	hex, err := NewHextree(1)
+	c.Assert(err, check.IsNil)
+	c.Check(hex.layerCounts, check.HasLen, 0)
+	c.Check(hex.values, check.HasLen, 1)
+	hex, err = NewHextree(3)
+	c.Assert(err, check.IsNil)
+	c.Check(hex.layerCounts, check.HasLen, 2)
+	c.Check(hex.layerCounts[0], check.HasLen, 16)
+	c.Check(hex.layerCounts[1], check.HasLen, 256)
+	c.Check(hex.values, check.HasLen, 256)
+	c.Check(hex.alphaWeight, check.Equals, 1.0)
+}
+
+func (*HextreeSuite) TestNewHextreeInvalid(c *check.C) {
+	hex, err := NewHextree(0)
+	c.Check(err, check.ErrorMatches, "Invalid hextree depth: 0")
+	c.Check(hex, check.IsNil)
+	hex, err = NewHextree(6)
+	c.Check(err, check.ErrorMatches, "Invalid hextree depth: 6")
+	c.Check(hex, check.IsNil)
+}
+
+func (*HextreeSuite) TestSetAlphaWeight(c *check.C) {
+	hex, err := NewHextree(2)
+	c.Assert(err, check.IsNil)
+	c.Check(hex.SetAlphaWeight(0.25), check.IsNil)
+	c.Check(hex.alphaWeight, check.Equals, 0.25)
+	c.Check(hex.SetAlphaWeight(-1), check.ErrorMatches, "Invalid alpha weight: -1")
+	c.Check(hex.SetAlphaWeight(math.NaN()), check.ErrorMatches,
+		"Invalid alpha weight: NaN")
+	c.Check(hex.alphaWeight, check.Equals, 0.25)
+}
+
+func (*HextreeSuite) TestInterleaveRGBA(c *check.C) {
+	c.Check(interleaveRGBA(0, 0, 0, 0), check.Equals, uint32(0))
+	c.Check(interleaveRGBA(0, 0, 0, 1), check.Equals, uint32(0x1))
+	c.Check(interleaveRGBA(0, 0, 1, 0), check.Equals, uint32(0x2))
+	c.Check(interleaveRGBA(0, 1, 0, 0), check.Equals, uint32(0x4))
+	c.Check(interleaveRGBA(1, 0, 0, 0), check.Equals, uint32(0x8))
+	c.Check(interleaveRGBA(0x80, 0, 0, 0), check.Equals, uint32(0x80000000))
+	c.Check(interleaveRGBA(0, 0, 0, 0x80), check.Equals, uint32(0x10000000))
+	c.Check(interleaveRGBA(0xFF, 0xFF, 0xFF, 0xFF), check.Equals, uint32(0xFFFFFFFF))
+	for _, vals := range [][4]uint8{{1, 2, 3, 4}, {0xFF, 0, 0x80, 0x7F}, {9, 99, 199, 255}} {
+		r, g, b, a := interleavedToRGBA(interleaveRGBA(vals[0], vals[1], vals[2], vals[3]))
+		c.Check([4]uint8{r, g, b, a}, check.Equals, vals)
+	}
+}
+
+func (*HextreeSuite) TestAdd(c *check.C) {
+	hex, err := NewHextree(3)
+	c.Assert(err, check.IsNil)
+	hex.Add(0x80, 0, 0, 0)
+	hex.Add(0x80, 0, 0, 0)
+	hex.Add(0, 0, 0, 0x80)
+	c.Check(hex.count, check.Equals, uint32(3))
+	c.Check(hex.layerCounts[0][8], check.Equals, uint32(2))
+	c.Check(hex.layerCounts[0][1], check.Equals, uint32(1))
+	c.Check(hex.layerCounts[1][8*16], check.Equals, uint32(2))
+	c.Check(hex.layerCounts[1][1*16], check.Equals, uint32(1))
+	c.Check(hex.values[8*16], check.DeepEquals,
+		[]*rgbaValue{{r: 0x80, count: 2}})
+	c.Check(hex.values[1*16], check.DeepEquals,
+		[]*rgbaValue{{a: 0x80, count: 1}})
+}
+
+func (*HextreeSuite) TestFindClosest(c *check.C) {
+	hex, err := NewHextree(4)
+	c.Assert(err, check.IsNil)
+	c.Check(hex.FindClosest(1, 2, 3, 4), check.Equals, rgbaValue{})
+	hex.Add(0, 0, 0, 0xFF)
+	hex.Add(0xFF, 0, 0, 0xFF)
+	hex.Add(0x10, 0x10, 0x10, 0x00)
+	c.Check(hex.FindClosest(0, 0, 0, 0xFF), check.Equals,
+		rgbaValue{a: 0xFF, count: 1})
+	c.Check(hex.FindClosest(0xE0, 0x10, 0x10, 0xF0), check.Equals,
+		rgbaValue{r: 0xFF, a: 0xFF, count: 1})
+	// Transparent black is closest to the nearly transparent gray
+	c.Check(hex.FindClosest(0, 0, 0, 0), check.Equals,
+		rgbaValue{r: 0x10, g: 0x10, b: 0x10, count: 1})
+	// Unless we don't care about alpha at all
+	c.Assert(hex.SetAlphaWeight(0), check.IsNil)
+	c.Check(hex.FindClosest(0, 0, 0, 0), check.Equals,
+		rgbaValue{a: 0xFF, count: 1})
+}
+
+func (*HextreeSuite) TestFindClosestMatchesBruteForce(c *check.C) {
+	rng := rand.New(rand.NewSource(1))
+	for _, weight := range []float64{1, 0.1, 4} {
+		hex, err := NewHextree(3)
+		c.Assert(err, check.IsNil)
+		c.Assert(hex.SetAlphaWeight(weight), check.IsNil)
+		var all []*rgbaValue
+		for i := 0; i < 200; i++ {
+			v := &rgbaValue{r: uint8(rng.Intn(256)), g: uint8(rng.Intn(256)),
+				b: uint8(rng.Intn(256)), a: uint8(rng.Intn(256))}
+			hex.Add(v.r, v.g, v.b, v.a)
+			all = append(all, v)
+		}
+		for i := 0; i < 200; i++ {
+			r, g, b, a := uint8(rng.Intn(256)), uint8(rng.Intn(256)),
+				uint8(rng.Intn(256)), uint8(rng.Intn(256))
+			best := math.Inf(1)
+			for _, v := range all {
+				best = math.Min(best, hex.dist2ToV(r, g, b, a, v))
+			}
+			found := hex.FindClosest(r, g, b, a)
+			c.Check(hex.dist2ToV(r, g, b, a, &found), check.Equals, best)
+		}
+	}
+}
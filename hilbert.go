@@ -0,0 +1,83 @@
+package octree
+
+// Hilbert curve encoding in 3D, following John Skilling, "Programming the
+// Hilbert curve", AIP Conf. Proc. 707, 381 (2004).
+// The coordinates are first transformed into the 'transpose' form of the
+// Hilbert index, which is then interleaved exactly like a Morton index. This
+// means each 3 bits of the index still pick one of 8 children, so the
+// layerCounts structure works unchanged. Like Morton, the top 3*n bits of an
+// index are the index of its block in an n-layer curve, so a block can be
+// decoded directly without knowing the exact value inside of it.
+
+// Map r,g,b (each with 'order' bits) to their position along a Hilbert curve
+// filling a cube 2^order on a side.
+func hilbertEncode(r, g, b uint8, order uint) uint32 {
+	x := [3]uint32{uint32(r), uint32(g), uint32(b)}
+	if order == 0 {
+		return 0
+	}
+	m := uint32(1) << (order - 1)
+	// Inverse undo
+	for q := m; q > 1; q >>= 1 {
+		p := q - 1
+		for i := range x {
+			if x[i]&q != 0 {
+				x[0] ^= p
+			} else {
+				t := (x[0] ^ x[i]) & p
+				x[0] ^= t
+				x[i] ^= t
+			}
+		}
+	}
+	// Gray encode
+	x[1] ^= x[0]
+	x[2] ^= x[1]
+	t := uint32(0)
+	for q := m; q > 1; q >>= 1 {
+		if x[2]&q != 0 {
+			t ^= q - 1
+		}
+	}
+	for i := range x {
+		x[i] ^= t
+	}
+	return interleaveRGB(uint8(x[0]), uint8(x[1]), uint8(x[2]))
+}
+
+// This inverts the effect of hilbertEncode.
+func hilbertDecode(index uint32, order uint) (r, g, b uint8) {
+	tr, tg, tb := interleavedToRGB(index)
+	x := [3]uint32{uint32(tr), uint32(tg), uint32(tb)}
+	// Gray decode by H ^ (H/2)
+	t := x[2] >> 1
+	x[2] ^= x[1]
+	x[1] ^= x[0]
+	x[0] ^= t
+	// Undo excess work
+	n := uint32(1) << order
+	for q := uint32(2); q < n; q <<= 1 {
+		p := q - 1
+		for i := len(x) - 1; i >= 0; i-- {
+			if x[i]&q != 0 {
+				x[0] ^= p
+			} else {
+				t := (x[0] ^ x[i]) & p
+				x[0] ^= t
+				x[i] ^= t
+			}
+		}
+	}
+	return uint8(x[0]), uint8(x[1]), uint8(x[2])
+}
+
+// The Hilbert equivalent of interleaveRGB, using the full 8 bits of each
+// channel.
+func hilbertRGB(r, g, b uint8) uint32 {
+	return hilbertEncode(r, g, b, 8)
+}
+
+// This inverts the effect of hilbertRGB.
+func hilbertToRGB(index uint32) (r, g, b uint8) {
+	return hilbertDecode(index, 8)
+}
@@ -0,0 +1,180 @@
+package octree
+
+import (
+	"math/rand"
+	"slices"
+
+	"gopkg.in/check.v1"
+)
+
+type Hilbert3DSuite struct{}
+
+var _ = check.Suite(&Hilbert3DSuite{})
+
+// Results so far:
+//    61,607,757 Interleave3DLUT
+// 1,949,703,018 Hilbert3D
+// 2,250,898,798 Hilbert3DDecode
+//     6,161,543 FindClosestMorton
+//     7,709,515 FindClosestHilbert
+// The Hilbert curve is about 30x slower to compute than a Morton lookup, and
+// at the sizes we use the better locality doesn't win that back for
+// FindClosest.
+
+func absDiff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func checkHilbertCurve(c *check.C, order uint) {
+	size := 1 << order
+	seen := make([]bool, size*size*size)
+	var lastR, lastG, lastB uint8
+	for index := 0; index < size*size*size; index++ {
+		r, g, b := hilbertDecode(uint32(index), order)
+		c.Assert(int(r) < size && int(g) < size && int(b) < size,
+			check.Equals, true)
+		pos := (int(r)*size+int(g))*size + int(b)
+		c.Assert(seen[pos], check.Equals, false)
+		seen[pos] = true
+		c.Assert(hilbertEncode(r, g, b, order), check.Equals, uint32(index))
+		if index > 0 {
+			// Every step along the curve moves exactly one unit along
+			// exactly one axis
+			step := int(absDiff(r, lastR)) + int(absDiff(g, lastG)) +
+				int(absDiff(b, lastB))
+			c.Assert(step, check.Equals, 1,
+				check.Commentf("step from %d to %d", index-1, index))
+		}
+		lastR, lastG, lastB = r, g, b
+	}
+}
+
+func (*Hilbert3DSuite) TestHilbertCurveSmall(c *check.C) {
+	c.Check(hilbertEncode(0, 0, 0, 0), check.Equals, uint32(0))
+	checkHilbertCurve(c, 1)
+	checkHilbertCurve(c, 2)
+	checkHilbertCurve(c, 3)
+	checkHilbertCurve(c, 4)
+}
+
+func (*Hilbert3DSuite) TestHilbertRGBRoundTrip(c *check.C) {
+	c.Check(hilbertRGB(0, 0, 0), check.Equals, uint32(0))
+	for r := 0; r <= 0xFF; r += 7 {
+		for g := 0; g <= 0xFF; g += 5 {
+			for b := 0; b <= 0xFF; b += 3 {
+				index := hilbertRGB(uint8(r), uint8(g), uint8(b))
+				c.Assert(index <= 0xFFFFFF, check.Equals, true)
+				r2, g2, b2 := hilbertToRGB(index)
+				c.Assert([]uint8{r2, g2, b2}, check.DeepEquals,
+					[]uint8{uint8(r), uint8(g), uint8(b)})
+			}
+		}
+	}
+}
+
+func (*Hilbert3DSuite) TestHilbertPrefix(c *check.C) {
+	// The top bits of an index are the index of the block in a coarser
+	// curve. This is what lets us use the same layerCounts as Morton.
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		r, g, b := uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256))
+		index := hilbertRGB(r, g, b)
+		for order := uint(0); order <= 8; order++ {
+			shift := 8 - order
+			c.Assert(index>>(3*shift), check.Equals,
+				hilbertEncode(r>>shift, g>>shift, b>>shift, order))
+		}
+	}
+}
+
+func (*Hilbert3DSuite) TestHilbertOctree(c *check.C) {
+	oct, err := NewOctree(3, WithOrdering(HilbertOrder))
+	c.Assert(err, check.IsNil)
+	oct.Add(0x40, 0x00, 0x00)
+	oct.Add(0x00, 0x00, 0x00)
+	index := hilbertRGB(0x40, 0x00, 0x00)
+	c.Check(oct.values[index>>18], check.DeepEquals,
+		[]*value{{r: 0x40, g: 0x00, b: 0x00, count: 1}})
+	// Both values are in the same top level block
+	c.Check(oct.layerCounts[0][index>>21], check.Equals, uint32(2))
+	c.Check(oct.FindClosest(0x39, 0, 0), check.DeepEquals,
+		value{r: 0x40, g: 0, b: 0, count: 1})
+	c.Check(oct.FindClosest(0x10, 0, 0), check.DeepEquals,
+		value{r: 0x00, g: 0, b: 0, count: 1})
+	c.Check(oct.FindInBox(0x00, 0x00, 0x00, 0x40, 0x40, 0x40), check.HasLen, 2)
+	vMin, vMax := oct.findBlockMinMax(index >> 18)
+	c.Check(vMin, check.DeepEquals, value{r: 0x40, g: 0x00, b: 0x00})
+	c.Check(vMax, check.DeepEquals, value{r: 0x7F, g: 0x3F, b: 0x3F})
+}
+
+func (*Hilbert3DSuite) TestHilbertNeighborBlocks(c *check.C) {
+	// Both orderings must find the same blocks, just numbered differently
+	morton, err := NewOctree(4)
+	c.Assert(err, check.IsNil)
+	hilbert, err := NewOctree(4, WithOrdering(HilbertOrder))
+	c.Assert(err, check.IsNil)
+	for _, coords := range [][3]uint8{{0, 0, 0}, {7, 7, 7}, {3, 4, 5}, {0, 7, 2}} {
+		mIndex := interleaveRGB(coords[0], coords[1], coords[2])
+		hIndex := hilbertEncode(coords[0], coords[1], coords[2], 3)
+		mNeighbors, mMin, mMax := morton.find26NeighborBlocks(mIndex)
+		hNeighbors, hMin, hMax := hilbert.find26NeighborBlocks(hIndex)
+		c.Check(hMin, check.DeepEquals, mMin)
+		c.Check(hMax, check.DeepEquals, mMax)
+		c.Assert(hNeighbors, check.HasLen, len(mNeighbors))
+		for i, hIndex := range hNeighbors {
+			if i > 0 {
+				c.Check(hNeighbors[i-1] < hIndex, check.Equals, true)
+			}
+			r, g, b := hilbertDecode(hIndex, 3)
+			c.Check(slices.Contains(mNeighbors, interleaveRGB(r, g, b)),
+				check.Equals, true)
+		}
+	}
+}
+
+func (*Hilbert3DSuite) TestInvalidOrdering(c *check.C) {
+	oct, err := NewOctree(3, WithOrdering(Ordering(5)))
+	c.Check(err, check.ErrorMatches, "Invalid octree ordering: 5")
+	c.Check(oct, check.IsNil)
+}
+
+func (*Hilbert3DSuite) BenchmarkHilbert3D(c *check.C) {
+	benchInterleave3D(c, hilbertRGB)
+}
+
+func (*Hilbert3DSuite) BenchmarkHilbert3DDecode(c *check.C) {
+	benchInterleave3D(c, func(x, y, z uint8) uint32 {
+		r, _, _ := hilbertToRGB(interleaveRGB(x, y, z))
+		return uint32(r)
+	})
+}
+
+// Look up colors that are near each other, which is what we mostly do when
+// remapping an image.
+func benchFindClosest(c *check.C, ordering Ordering) {
+	oct, err := NewOctree(5, WithOrdering(ordering))
+	c.Assert(err, check.IsNil)
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		oct.Add(uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)))
+	}
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		for r := 0; r < 256; r += 4 {
+			for g := 0; g < 256; g += 16 {
+				oct.FindClosest(uint8(r), uint8(g), uint8(r+g))
+			}
+		}
+	}
+}
+
+func (*Hilbert3DSuite) BenchmarkFindClosestMorton(c *check.C) {
+	benchFindClosest(c, MortonOrder)
+}
+
+func (*Hilbert3DSuite) BenchmarkFindClosestHilbert(c *check.C) {
+	benchFindClosest(c, HilbertOrder)
+}
@@ -0,0 +1,136 @@
+package octree
+
+import (
+	"iter"
+	"slices"
+)
+
+// Node describes one non-empty block of the tree.
+type Node struct {
+	// The root is level 0
+	Level int
+	// The index of the block within its level, in the ordering of the tree
+	Index uint32
+	// The total count of the values in the block
+	Count uint64
+	// The inclusive bounds of the block, as r, g, b
+	Min, Max [3]uint8
+}
+
+// Entries iterates over every value in the tree, in Morton order.
+func (o *Octree) Entries() iter.Seq[value] {
+	return func(yield func(value) bool) {
+		o.yieldEntries(o.rootCursor(), nil, yield)
+	}
+}
+
+// Nodes iterates over the non-empty blocks at level, in block order. The
+// root is level 0, and the deepest level is Depth()-1. A tree with adaptive
+// leaves only has the blocks that it has split down to.
+func (o *Octree) Nodes(level int) iter.Seq[Node] {
+	return func(yield func(Node) bool) {
+		if level < 0 || level >= o.depth || o.count == 0 {
+			return
+		}
+		o.yieldNodes(o.rootCursor(), uint(level), yield)
+	}
+}
+
+// NodeEntries iterates over every value inside the block described by n, in
+// Morton order. Only n.Level and n.Index are used.
+func (o *Octree) NodeEntries(n Node) iter.Seq[value] {
+	return func(yield func(value) bool) {
+		if n.Level < 0 || n.Level >= o.depth || uint64(n.Index) >= 1<<(3*uint(n.Level)) {
+			return
+		}
+		c, ok := o.findCursor(uint(n.Level), n.Index)
+		if !ok {
+			return
+		}
+		var filter *[2]value
+		if c.level < uint(n.Level) {
+			// An adaptive leaf that covers more than the block
+			vMin, vMax := o.blockMinMax(uint(n.Level), n.Index)
+			filter = &[2]value{vMin, vMax}
+		}
+		o.yieldEntries(c, filter, yield)
+	}
+}
+
+// Find the block at level with the given index, or the leaf above it that
+// covers it. false if the block is empty.
+func (o *Octree) findCursor(level uint, index uint32) (cursor, bool) {
+	if o.root == nil {
+		c := cursor{level: level, index: index}
+		return c, o.cursorCount(c) > 0
+	}
+	c := o.rootCursor()
+	for c.level < level && c.n.children != nil {
+		slot := index >> (3 * (level - c.level - 1)) & 0x7
+		child := c.n.children[slot]
+		if child == nil {
+			return cursor{}, false
+		}
+		c = cursor{level: c.level + 1, index: c.index<<3 | slot, n: child}
+	}
+	return c, c.n.count > 0
+}
+
+// Yield the values under c in Morton order, optionally only those inside
+// the inclusive box filter. Returns false once yield does.
+func (o *Octree) yieldEntries(c cursor, filter *[2]value, yield func(value) bool) bool {
+	if o.ordering != MortonOrder {
+		// Blocks aren't in Morton order, so everything has to be sorted
+		var values []*value
+		o.eachLeaf(c, func(leaf []*value) {
+			values = append(values, leaf...)
+		})
+		return yieldSorted(values, filter, yield)
+	}
+	if o.cursorIsLeaf(c) {
+		return yieldSorted(slices.Clone(o.cursorValues(c)), filter, yield)
+	}
+	var buf [8]cursor
+	for _, child := range o.appendChildren(buf[:0], c) {
+		if !o.yieldEntries(child, filter, yield) {
+			return false
+		}
+	}
+	return true
+}
+
+func yieldSorted(values []*value, filter *[2]value, yield func(value) bool) bool {
+	slices.SortFunc(values, func(x, y *value) int {
+		return int(interleaveRGB(x.r, x.g, x.b)) - int(interleaveRGB(y.r, y.g, y.b))
+	})
+	for _, v := range values {
+		if filter != nil && dist2ToBox(v.r, v.g, v.b, filter[0], filter[1]) > 0 {
+			continue
+		}
+		if !yield(*v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Yield the non-empty blocks under c at level. Returns false once yield does.
+func (o *Octree) yieldNodes(c cursor, level uint, yield func(Node) bool) bool {
+	if c.level == level {
+		vMin, vMax := o.cursorMinMax(c)
+		return yield(Node{
+			Level: int(c.level),
+			Index: c.index,
+			Count: o.cursorCount(c),
+			Min:   [3]uint8{vMin.r, vMin.g, vMin.b},
+			Max:   [3]uint8{vMax.r, vMax.g, vMax.b},
+		})
+	}
+	var buf [8]cursor
+	for _, child := range o.appendChildren(buf[:0], c) {
+		if !o.yieldNodes(child, level, yield) {
+			return false
+		}
+	}
+	return true
+}
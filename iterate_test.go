@@ -0,0 +1,124 @@
+package octree
+
+import (
+	"math/rand"
+	"slices"
+
+	"gopkg.in/check.v1"
+)
+
+type IterateSuite struct{}
+
+var _ = check.Suite(&IterateSuite{})
+
+var iterateOptions = [][]Option{
+	nil,
+	{WithOrdering(HilbertOrder)},
+	{WithStorage(SparseStorage)},
+	{WithAdaptiveLeaves(4)},
+}
+
+func (*IterateSuite) TestEntries(c *check.C) {
+	rng := rand.New(rand.NewSource(1))
+	for _, options := range iterateOptions {
+		oct, err := NewOctree(4, options...)
+		c.Assert(err, check.IsNil)
+		c.Check(slices.Collect(oct.Entries()), check.HasLen, 0)
+		for i := 0; i < 500; i++ {
+			oct.Add(uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)))
+		}
+		var expected []value
+		for _, v := range oct.sortedValues() {
+			expected = append(expected, *v)
+		}
+		c.Check(slices.Collect(oct.Entries()), check.DeepEquals, expected)
+		// Stopping early
+		var first []value
+		for v := range oct.Entries() {
+			if len(first) == 3 {
+				break
+			}
+			first = append(first, v)
+		}
+		c.Check(first, check.DeepEquals, expected[:3])
+	}
+}
+
+func (*IterateSuite) TestNodes(c *check.C) {
+	for _, options := range [][]Option{nil, {WithStorage(SparseStorage)}} {
+		oct, err := NewOctree(3, options...)
+		c.Assert(err, check.IsNil)
+		c.Check(slices.Collect(oct.Nodes(0)), check.HasLen, 0)
+		oct.Add(0x00, 0x00, 0x00)
+		oct.Add(0x01, 0x00, 0x00)
+		oct.Add(0xFF, 0xFF, 0xFF)
+		c.Check(slices.Collect(oct.Nodes(0)), check.DeepEquals, []Node{
+			{Level: 0, Count: 3, Max: [3]uint8{0xFF, 0xFF, 0xFF}},
+		})
+		c.Check(slices.Collect(oct.Nodes(2)), check.DeepEquals, []Node{
+			{Level: 2, Index: 0, Count: 2, Max: [3]uint8{0x3F, 0x3F, 0x3F}},
+			{Level: 2, Index: 63, Count: 1,
+				Min: [3]uint8{0xC0, 0xC0, 0xC0}, Max: [3]uint8{0xFF, 0xFF, 0xFF}},
+		})
+		c.Check(slices.Collect(oct.Nodes(3)), check.HasLen, 0)
+		c.Check(slices.Collect(oct.Nodes(-1)), check.HasLen, 0)
+		for n := range oct.Nodes(1) {
+			c.Check(n.Index, check.Equals, uint32(0))
+			break
+		}
+	}
+}
+
+func (*IterateSuite) TestNodeEntries(c *check.C) {
+	rng := rand.New(rand.NewSource(2))
+	for _, options := range iterateOptions {
+		oct, err := NewOctree(4, options...)
+		c.Assert(err, check.IsNil)
+		for i := 0; i < 300; i++ {
+			oct.Add(uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)))
+		}
+		all := slices.Collect(oct.Entries())
+		for level := 0; level < 4; level++ {
+			// Every entry is in exactly one node of each level, and the
+			// node's entries add up to its count, as long as the tree has
+			// been split down to it
+			var entries []value
+			for n := range oct.Nodes(level) {
+				sum := uint64(0)
+				for v := range oct.NodeEntries(n) {
+					c.Check(dist2ToBox(v.r, v.g, v.b,
+						value{r: n.Min[0], g: n.Min[1], b: n.Min[2]},
+						value{r: n.Max[0], g: n.Max[1], b: n.Max[2]}), check.Equals, uint32(0))
+					sum += v.count
+					entries = append(entries, v)
+				}
+				c.Check(sum, check.Equals, n.Count)
+			}
+			if oct.maxLeafSize > 0 {
+				// Adaptive leaves don't all go down to every level
+				continue
+			}
+			slices.SortFunc(entries, func(x, y value) int {
+				return int(interleaveRGB(x.r, x.g, x.b)) - int(interleaveRGB(y.r, y.g, y.b))
+			})
+			c.Check(entries, check.DeepEquals, all)
+		}
+	}
+}
+
+func (*IterateSuite) TestNodeEntriesAdaptiveLeaf(c *check.C) {
+	oct, err := NewOctree(4, WithAdaptiveLeaves(4))
+	c.Assert(err, check.IsNil)
+	oct.Add(0x00, 0x00, 0x00)
+	oct.Add(0x00, 0x00, 0x01)
+	oct.Add(0xFF, 0x00, 0x00)
+	// Nothing has split, but we can still ask about blocks below the root
+	c.Check(slices.Collect(oct.Nodes(1)), check.HasLen, 0)
+	c.Check(slices.Collect(oct.NodeEntries(Node{Level: 2, Index: 0})), check.DeepEquals,
+		[]value{{count: 1}, {b: 1, count: 1}})
+	c.Check(slices.Collect(oct.NodeEntries(Node{Level: 1, Index: 4})), check.DeepEquals,
+		[]value{{r: 0xFF, count: 1}})
+	c.Check(slices.Collect(oct.NodeEntries(Node{Level: 1, Index: 7})), check.HasLen, 0)
+	c.Check(slices.Collect(oct.NodeEntries(Node{Level: 1, Index: 8})), check.HasLen, 0)
+	c.Check(slices.Collect(oct.NodeEntries(Node{Level: 4})), check.HasLen, 0)
+}
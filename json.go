@@ -0,0 +1,196 @@
+package octree
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Colors are written to JSON as "#rrggbb" hex strings.
+func hexColor(r, g, b uint8) string {
+	return fmt.Sprintf("#%02x%02x%02x", r, g, b)
+}
+
+// Parse a "#rrggbb" hex string. The leading '#' is optional.
+func parseHexColor(s string) (r, g, b uint8, err error) {
+	hex := s
+	if len(hex) > 0 && hex[0] == '#' {
+		hex = hex[1:]
+	}
+	if len(hex) != 6 {
+		return 0, 0, 0, fmt.Errorf("Invalid hex color: %q", s)
+	}
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("Invalid hex color: %q", s)
+	}
+	return uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb), nil
+}
+
+type valueJSON struct {
+	Color string `json:"color"`
+	Count uint64 `json:"count"`
+}
+
+// MarshalJSON implements json.Marshaler, as {"color": "#rrggbb", "count": n}
+func (v value) MarshalJSON() ([]byte, error) {
+	return json.Marshal(valueJSON{Color: hexColor(v.r, v.g, v.b), Count: v.count})
+}
+
+// UnmarshalJSON implements json.Unmarshaler, the inverse of MarshalJSON.
+func (v *value) UnmarshalJSON(data []byte) error {
+	var vj valueJSON
+	if err := json.Unmarshal(data, &vj); err != nil {
+		return err
+	}
+	r, g, b, err := parseHexColor(vj.Color)
+	if err != nil {
+		return err
+	}
+	*v = value{r: r, g: g, b: b, count: vj.Count}
+	return nil
+}
+
+type octreeJSON struct {
+	Depth       int         `json:"depth"`
+	Ordering    string      `json:"ordering"`
+	Storage     string      `json:"storage"`
+	MaxLeafSize int         `json:"maxLeafSize,omitempty"`
+	Counts64    bool        `json:"counts64,omitempty"`
+	NodeStats   bool        `json:"nodeStats,omitempty"`
+	Count       uint64      `json:"count"`
+	Colors      []value     `json:"colors"`
+	Layers      []layerJSON `json:"layers,omitempty"`
+}
+
+// The non-empty blocks at one level of the tree.
+type layerJSON struct {
+	Level  int         `json:"level"`
+	Blocks []blockJSON `json:"blocks"`
+}
+
+type blockJSON struct {
+	Min   string `json:"min"`
+	Max   string `json:"max"`
+	Count uint64 `json:"count"`
+}
+
+func (o *Octree) toJSON(withLayers bool) octreeJSON {
+	oj := octreeJSON{
+		Depth:       o.depth,
+		Ordering:    o.ordering.String(),
+		Storage:     DenseStorage.String(),
+		MaxLeafSize: o.maxLeafSize,
+		Counts64:    o.maxCount > math.MaxUint32,
+		NodeStats:   o.layerTotals != nil || o.nodeStats,
+		Count:       o.count,
+		Colors:      []value{},
+	}
+	if o.root != nil {
+		oj.Storage = SparseStorage.String()
+	}
+	for _, v := range o.sortedValues() {
+		oj.Colors = append(oj.Colors, *v)
+	}
+	if withLayers {
+		for level := 1; level < o.depth; level++ {
+			oj.Layers = append(oj.Layers, layerJSON{Level: level, Blocks: []blockJSON{}})
+		}
+		o.summarizeLayers(o.rootCursor(), oj.Layers)
+	}
+	return oj
+}
+
+// Add every non-empty block below c to the summary of its layer.
+func (o *Octree) summarizeLayers(c cursor, layers []layerJSON) {
+	var buf [8]cursor
+	for _, child := range o.appendChildren(buf[:0], c) {
+		vMin, vMax := o.cursorMinMax(child)
+		layer := &layers[child.level-1]
+		layer.Blocks = append(layer.Blocks, blockJSON{
+			Min:   hexColor(vMin.r, vMin.g, vMin.b),
+			Max:   hexColor(vMax.r, vMax.g, vMax.b),
+			Count: o.cursorCount(child),
+		})
+		o.summarizeLayers(child, layers)
+	}
+}
+
+// MarshalJSON implements json.Marshaler. Colors are listed in Morton order,
+// like MarshalBinary.
+func (o *Octree) MarshalJSON() ([]byte, error) {
+	return json.Marshal(o.toJSON(false))
+}
+
+// LayerSummaries wraps an Octree so that its JSON also lists the non-empty
+// blocks of every layer below the root, with their bounds and counts.
+type LayerSummaries struct {
+	*Octree
+}
+
+// MarshalJSON implements json.Marshaler.
+func (l LayerSummaries) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l.Octree.toJSON(true))
+}
+
+// UnmarshalJSON implements json.Unmarshaler. It replaces the contents of the
+// tree, including its depth and options. Any layer summaries are ignored, the
+// layers are rebuilt from the colors.
+func (o *Octree) UnmarshalJSON(data []byte) error {
+	var oj octreeJSON
+	if err := json.Unmarshal(data, &oj); err != nil {
+		return err
+	}
+	var options []Option
+	switch oj.Ordering {
+	case "", MortonOrder.String():
+	case HilbertOrder.String():
+		options = append(options, WithOrdering(HilbertOrder))
+	default:
+		return fmt.Errorf("Invalid octree ordering: %q", oj.Ordering)
+	}
+	switch oj.Storage {
+	case "", AutoStorage.String():
+	case DenseStorage.String():
+		options = append(options, WithStorage(DenseStorage))
+	case SparseStorage.String():
+		options = append(options, WithStorage(SparseStorage))
+	default:
+		return fmt.Errorf("Invalid octree storage: %q", oj.Storage)
+	}
+	options = append(options, WithAdaptiveLeaves(oj.MaxLeafSize))
+	if oj.Counts64 {
+		options = append(options, WithCounts64())
+	}
+	if oj.NodeStats {
+		options = append(options, WithNodeStats())
+	}
+	tree, err := NewOctree(oj.Depth, options...)
+	if err != nil {
+		return err
+	}
+	seen := make(map[uint32]bool, len(oj.Colors))
+	for _, v := range oj.Colors {
+		if v.count == 0 {
+			return fmt.Errorf("Invalid octree color %s: count must not be 0",
+				hexColor(v.r, v.g, v.b))
+		}
+		// Like UnmarshalBinary, each color is only listed once
+		if seen[packRGB(v.r, v.g, v.b)] {
+			return fmt.Errorf("Invalid octree color %s: listed more than once",
+				hexColor(v.r, v.g, v.b))
+		}
+		seen[packRGB(v.r, v.g, v.b)] = true
+		if v.count > tree.maxCount-tree.count {
+			return fmt.Errorf("Invalid octree: counts add up to more than %d", tree.maxCount)
+		}
+		tree.addCount(v.r, v.g, v.b, v.count)
+	}
+	if tree.count != oj.Count {
+		return fmt.Errorf("Invalid octree: colors add up to %d, not the count %d",
+			tree.count, oj.Count)
+	}
+	*o = *tree
+	return nil
+}
@@ -0,0 +1,161 @@
+package octree
+
+import (
+	"encoding/json"
+
+	"gopkg.in/check.v1"
+)
+
+type JSONSuite struct{}
+
+var _ = check.Suite(&JSONSuite{})
+
+func (*JSONSuite) TestValueJSON(c *check.C) {
+	data, err := json.Marshal(value{r: 0xFF, g: 0x08, b: 0x00, count: 3})
+	c.Assert(err, check.IsNil)
+	c.Check(string(data), check.Equals, `{"color":"#ff0800","count":3}`)
+	var v value
+	c.Assert(json.Unmarshal([]byte(`{"color":"#0A0b0c","count":2}`), &v), check.IsNil)
+	c.Check(v, check.Equals, value{r: 0x0A, g: 0x0B, b: 0x0C, count: 2})
+	c.Assert(json.Unmarshal([]byte(`{"color":"010203","count":1}`), &v), check.IsNil)
+	c.Check(v, check.Equals, value{r: 1, g: 2, b: 3, count: 1})
+	for _, color := range []string{"", "#fff", "#12345", "#1234567", "#gg0000", "#+12345"} {
+		err := json.Unmarshal([]byte(`{"color":"`+color+`","count":1}`), &v)
+		c.Check(err, check.ErrorMatches, `Invalid hex color: ".*"`, check.Commentf(color))
+	}
+}
+
+func (*JSONSuite) TestQueryResultsJSON(c *check.C) {
+	oct, err := NewOctree(3)
+	c.Assert(err, check.IsNil)
+	oct.Add(0x10, 0x10, 0x10)
+	oct.Add(0x20, 0x10, 0x10)
+	data, err := json.Marshal(oct.FindInBox(0, 0, 0, 0x20, 0x20, 0x20))
+	c.Assert(err, check.IsNil)
+	c.Check(string(data), check.Equals,
+		`[{"color":"#101010","count":1},{"color":"#201010","count":1}]`)
+}
+
+func (*JSONSuite) TestMarshalOctree(c *check.C) {
+	oct, err := NewOctree(2)
+	c.Assert(err, check.IsNil)
+	data, err := json.Marshal(oct)
+	c.Assert(err, check.IsNil)
+	c.Check(string(data), check.Equals,
+		`{"depth":2,"ordering":"morton","storage":"dense","count":0,"colors":[]}`)
+	oct.Add(0xFF, 0x00, 0x00)
+	oct.Add(0x01, 0x02, 0x03)
+	oct.Add(0xFF, 0x00, 0x00)
+	data, err = json.Marshal(oct)
+	c.Assert(err, check.IsNil)
+	// Colors are in Morton order
+	c.Check(string(data), check.Equals,
+		`{"depth":2,"ordering":"morton","storage":"dense","count":3,"colors":[`+
+			`{"color":"#010203","count":1},{"color":"#ff0000","count":2}]}`)
+	oct, err = NewOctree(9, WithOrdering(HilbertOrder), WithAdaptiveLeaves(4))
+	c.Assert(err, check.IsNil)
+	data, err = json.Marshal(oct)
+	c.Assert(err, check.IsNil)
+	c.Check(string(data), check.Equals,
+		`{"depth":9,"ordering":"hilbert","storage":"sparse","maxLeafSize":4,"count":0,"colors":[]}`)
+}
+
+func (*JSONSuite) TestMarshalLayerSummaries(c *check.C) {
+	for _, storage := range []Storage{DenseStorage, SparseStorage} {
+		oct, err := NewOctree(3, WithStorage(storage))
+		c.Assert(err, check.IsNil)
+		oct.Add(0xFF, 0x00, 0x00)
+		oct.Add(0xFF, 0x00, 0x00)
+		oct.Add(0x01, 0x02, 0x03)
+		data, err := json.Marshal(LayerSummaries{oct})
+		c.Assert(err, check.IsNil)
+		var decoded struct {
+			Layers []layerJSON `json:"layers"`
+		}
+		c.Assert(json.Unmarshal(data, &decoded), check.IsNil)
+		c.Check(decoded.Layers, check.DeepEquals, []layerJSON{{
+			Level: 1,
+			Blocks: []blockJSON{
+				{Min: "#000000", Max: "#7f7f7f", Count: 1},
+				{Min: "#800000", Max: "#ff7f7f", Count: 2},
+			},
+		}, {
+			Level: 2,
+			Blocks: []blockJSON{
+				{Min: "#000000", Max: "#3f3f3f", Count: 1},
+				{Min: "#c00000", Max: "#ff3f3f", Count: 2},
+			},
+		}})
+	}
+	// A tree of just the root has no layers to summarize
+	oct, err := NewOctree(1)
+	c.Assert(err, check.IsNil)
+	data, err := json.Marshal(LayerSummaries{oct})
+	c.Assert(err, check.IsNil)
+	c.Check(string(data), check.Equals,
+		`{"depth":1,"ordering":"morton","storage":"dense","count":0,"colors":[]}`)
+}
+
+func (*JSONSuite) TestRoundTripJSON(c *check.C) {
+	for _, options := range [][]Option{
+		nil,
+		{WithOrdering(HilbertOrder)},
+		{WithStorage(SparseStorage)},
+		{WithAdaptiveLeaves(2)},
+		{WithCounts64()},
+		{WithNodeStats()},
+	} {
+		oct, err := NewOctree(4, options...)
+		c.Assert(err, check.IsNil)
+		for i := 0; i < 50; i++ {
+			oct.Add(uint8(i*5), uint8(i*3), uint8(i*7))
+			oct.Add(uint8(i*5), 0, 0)
+		}
+		data, err := json.Marshal(LayerSummaries{oct})
+		c.Assert(err, check.IsNil)
+		loaded := &Octree{}
+		c.Assert(json.Unmarshal(data, loaded), check.IsNil)
+		c.Check(loaded.depth, check.Equals, oct.depth)
+		c.Check(loaded.ordering, check.Equals, oct.ordering)
+		c.Check(loaded.maxLeafSize, check.Equals, oct.maxLeafSize)
+		c.Check(loaded.root != nil, check.Equals, oct.root != nil)
+		c.Check(loaded.count, check.Equals, oct.count)
+		c.Check(loaded.layerCounts, check.DeepEquals, oct.layerCounts)
+		c.Check(loaded.layerCounts64, check.DeepEquals, oct.layerCounts64)
+		c.Check(loaded.maxCount, check.Equals, oct.maxCount)
+		c.Check(loaded.sortedValues(), check.DeepEquals, oct.sortedValues())
+		c.Check(loaded.layerTotals, check.DeepEquals, oct.layerTotals)
+	}
+}
+
+func (*JSONSuite) TestUnmarshalErrors(c *check.C) {
+	for _, test := range []struct {
+		data string
+		err  string
+	}{
+		{`[]`, `json: cannot unmarshal array .*`},
+		{`{"depth":0,"count":0}`, `Invalid octree depth: 0`},
+		{`{"depth":3,"ordering":"zorder"}`, `Invalid octree ordering: "zorder"`},
+		{`{"depth":3,"storage":"flat"}`, `Invalid octree storage: "flat"`},
+		{`{"depth":3,"maxLeafSize":2,"storage":"dense"}`, `Adaptive leaves need sparse storage`},
+		{`{"depth":3,"count":1,"colors":[{"color":"red","count":1}]}`, `Invalid hex color: "red"`},
+		{`{"depth":3,"count":0,"colors":[{"color":"#ff0000","count":0}]}`,
+			`Invalid octree color #ff0000: count must not be 0`},
+		{`{"depth":3,"count":2,"colors":[{"color":"#ff0000","count":1},{"color":"#ff0000","count":1}]}`,
+			`Invalid octree color #ff0000: listed more than once`},
+		{`{"depth":3,"count":2,"colors":[{"color":"#ff0000","count":1}]}`,
+			`Invalid octree: colors add up to 1, not the count 2`},
+		{`{"depth":3,"count":0,"colors":[{"color":"#ff0000","count":4294967295},` +
+			`{"color":"#00ff00","count":1}]}`,
+			`Invalid octree: counts add up to more than 4294967295`},
+	} {
+		oct, err := NewOctree(2)
+		c.Assert(err, check.IsNil)
+		oct.Add(1, 2, 3)
+		err = json.Unmarshal([]byte(test.data), oct)
+		c.Check(err, check.ErrorMatches, test.err, check.Commentf(test.data))
+		// Failing leaves the tree alone
+		c.Check(oct.depth, check.Equals, 2)
+		c.Check(oct.count, check.Equals, uint64(1))
+	}
+}
@@ -0,0 +1,258 @@
+package octree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// The file layout written by WriteMapped. It is designed to be searched in
+// place, so every block can be found without reading anything else first.
+// All integers are little-endian.
+//
+//	offset       size   field
+//	0            4      magic "OCTM"
+//	4            2      format version (currently 1)
+//	6            1      depth, the blocks are at depth-1, like a dense Octree
+//	7            1      reserved, must be 0
+//	8            8      total count
+//	16           4      number of entries (N)
+//	20           4      reserved, must be 0
+//	24           4*B+4  block offset table, B = 8^(depth-1)
+//	28+4B        12*N   entries, each r, g, b, 0 (1 byte each), then count
+//	                    (8 bytes)
+//
+// Entries are sorted by their Morton index (interleaveRGB), which also groups
+// them by block. Block i holds entries [offset[i], offset[i+1]), so the
+// offset table has one more entry than there are blocks, and the last offset
+// is always N.
+const (
+	mappedMagic      = "OCTM"
+	mappedVersion    = 1
+	mappedHeaderSize = 24
+	mappedEntrySize  = 12
+)
+
+// A read-only Octree that is searched directly from the bytes of a file
+// written by WriteMapped, without loading it into memory first.
+type MappedOctree struct {
+	data    []byte
+	depth   int
+	count   uint64
+	offsets []byte
+	entries []byte
+	// Release the data when we are done with it
+	closer func() error
+}
+
+// Write the tree in the layout described above mappedMagic. Trees deeper
+// than a dense Octree can be are written with blocks at the deepest dense
+// level instead.
+func (o *Octree) WriteMapped(w io.Writer) (int64, error) {
+	depth := o.depth
+	if depth > maxDenseDepth {
+		depth = maxDenseDepth
+	}
+	layers := uint(depth - 1)
+	blocks := 1 << (3 * layers)
+	values := o.sortedValues()
+	size := mappedHeaderSize + 4*(blocks+1) + mappedEntrySize*len(values)
+	data := make([]byte, mappedHeaderSize, size)
+	copy(data, mappedMagic)
+	binary.LittleEndian.PutUint16(data[4:], mappedVersion)
+	data[6] = uint8(depth)
+	binary.LittleEndian.PutUint64(data[8:], uint64(o.count))
+	binary.LittleEndian.PutUint32(data[16:], uint32(len(values)))
+	// values are sorted, so we just need to find where each block starts
+	next := 0
+	for block := 0; block <= blocks; block++ {
+		for next < len(values) {
+			v := values[next]
+			if int(interleaveRGB(v.r, v.g, v.b)>>(24-3*layers)) >= block {
+				break
+			}
+			next++
+		}
+		data = binary.LittleEndian.AppendUint32(data, uint32(next))
+	}
+	for _, v := range values {
+		data = append(data, v.r, v.g, v.b, 0)
+		data = binary.LittleEndian.AppendUint64(data, uint64(v.count))
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+// Open a file written by WriteMapped. Where the platform supports it the file
+// is mapped into memory rather than read. Close must be called once the tree
+// is no longer needed.
+func OpenMappedOctree(path string) (*MappedOctree, error) {
+	data, closer, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := NewMappedOctree(data)
+	if err != nil {
+		closer()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	m.closer = closer
+	return m, nil
+}
+
+// Search data written by WriteMapped in place. data must not be changed while
+// the tree is in use. The header and offset table are checked, but the
+// entries are not, so a corrupted file can give wrong answers.
+func NewMappedOctree(data []byte) (*MappedOctree, error) {
+	if len(data) < mappedHeaderSize {
+		return nil, fmt.Errorf("Truncated mapped octree: %d bytes is too short for the header",
+			len(data))
+	}
+	if string(data[:4]) != mappedMagic {
+		return nil, fmt.Errorf("Invalid mapped octree: bad magic %q", data[:4])
+	}
+	if version := binary.LittleEndian.Uint16(data[4:]); version != mappedVersion {
+		return nil, fmt.Errorf("Unsupported mapped octree version: %d", version)
+	}
+	depth := int(data[6])
+	if depth < 1 || depth > maxDenseDepth {
+		return nil, fmt.Errorf("Invalid mapped octree: invalid depth %d", depth)
+	}
+	if data[7] != 0 || binary.LittleEndian.Uint32(data[20:]) != 0 {
+		return nil, fmt.Errorf("Invalid mapped octree: reserved bytes are not 0")
+	}
+	entries := int(binary.LittleEndian.Uint32(data[16:]))
+	blocks := 1 << (3 * uint(depth-1))
+	entriesStart := mappedHeaderSize + 4*(blocks+1)
+	size := entriesStart + entries*mappedEntrySize
+	if len(data) != size {
+		return nil, fmt.Errorf("Invalid mapped octree: %d entries at depth %d needs %d bytes, have %d",
+			entries, depth, size, len(data))
+	}
+	m := &MappedOctree{
+		data:    data,
+		depth:   depth,
+		count:   binary.LittleEndian.Uint64(data[8:]),
+		offsets: data[mappedHeaderSize:entriesStart],
+		entries: data[entriesStart:],
+	}
+	last := uint32(0)
+	for block := 0; block <= blocks; block++ {
+		offset := m.offset(block)
+		if offset < last || int(offset) > entries {
+			return nil, fmt.Errorf("Invalid mapped octree: bad offset %d for block %d",
+				offset, block)
+		}
+		last = offset
+	}
+	if m.offset(0) != 0 || int(last) != entries {
+		return nil, fmt.Errorf("Invalid mapped octree: offsets don't cover the %d entries",
+			entries)
+	}
+	return m, nil
+}
+
+// Release the file backing the tree. The tree can't be used afterwards.
+func (m *MappedOctree) Close() error {
+	closer := m.closer
+	*m = MappedOctree{}
+	if closer == nil {
+		return nil
+	}
+	return closer()
+}
+
+// The number of levels in the tree, including the root.
+func (m *MappedOctree) Depth() int {
+	return m.depth
+}
+
+func (m *MappedOctree) offset(block int) uint32 {
+	return binary.LittleEndian.Uint32(m.offsets[4*block:])
+}
+
+func (m *MappedOctree) entry(i uint32) value {
+	e := m.entries[int(i)*mappedEntrySize:]
+	return value{r: e[0], g: e[1], b: e[2], count: binary.LittleEndian.Uint64(e[4:])}
+}
+
+// Find the stored value that is closest to r,g,b. The search starts with the
+// block holding r,g,b, and spirals out one shell of blocks at a time, until
+// nothing outside of what we have searched could be closer.
+func (m *MappedOctree) FindClosest(r, g, b uint8) value {
+	layers := uint(m.depth - 1)
+	shift := 8 - layers
+	width := 1 << shift
+	last := (1 << layers) - 1
+	q := [3]int{int(r) >> shift, int(g) >> shift, int(b) >> shift}
+	point := [3]int{int(r), int(g), int(b)}
+	var best value
+	found := false
+	bestDist2 := uint32(0)
+	for k := 0; k <= last; k++ {
+		var lo, hi [3]int
+		for axis := range q {
+			lo[axis] = max(q[axis]-k, 0)
+			hi[axis] = min(q[axis]+k, last)
+		}
+		for br := lo[0]; br <= hi[0]; br++ {
+			for bg := lo[1]; bg <= hi[1]; bg++ {
+				for bb := lo[2]; bb <= hi[2]; bb++ {
+					if max(abs(br-q[0]), abs(bg-q[1]), abs(bb-q[2])) != k {
+						// Searched in an earlier shell
+						continue
+					}
+					block := int(interleaveRGB(uint8(br), uint8(bg), uint8(bb)))
+					for i := m.offset(block); i < m.offset(block+1); i++ {
+						v := m.entry(i)
+						dist2 := dist2ToV(r, g, b, &v)
+						if !found || dist2 < bestDist2 {
+							best, bestDist2, found = v, dist2, true
+						}
+					}
+				}
+			}
+		}
+		if !found {
+			continue
+		}
+		// Anything we haven't searched is past one of the faces of the cube
+		// of blocks we have, unless that face is the edge of color space.
+		outside := -1
+		for axis := range q {
+			if lo[axis] > 0 {
+				d := point[axis] - lo[axis]*width + 1
+				if outside < 0 || d < outside {
+					outside = d
+				}
+			}
+			if hi[axis] < last {
+				d := (hi[axis]+1)*width - point[axis]
+				if outside < 0 || d < outside {
+					outside = d
+				}
+			}
+		}
+		if outside < 0 || bestDist2 <= uint32(outside*outside) {
+			break
+		}
+	}
+	return best
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// The fallback for platforms where we can't map the file, just read it all.
+func readFile(path string) ([]byte, func() error, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
@@ -0,0 +1,166 @@
+package octree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/check.v1"
+)
+
+type MappedSuite struct{}
+
+var _ = check.Suite(&MappedSuite{})
+
+func writeMapped(c *check.C, oct *Octree) []byte {
+	var buf bytes.Buffer
+	n, err := oct.WriteMapped(&buf)
+	c.Assert(err, check.IsNil)
+	c.Check(n, check.Equals, int64(buf.Len()))
+	return buf.Bytes()
+}
+
+func (*MappedSuite) TestWriteMapped(c *check.C) {
+	oct, err := NewOctree(2)
+	c.Assert(err, check.IsNil)
+	oct.Add(0xFF, 0x00, 0x00)
+	oct.Add(0x01, 0x02, 0x03)
+	oct.Add(0xFF, 0x00, 0x00)
+	oct.Add(0x80, 0x00, 0x01)
+	data := writeMapped(c, oct)
+	c.Check(data, check.DeepEquals, []byte{
+		'O', 'C', 'T', 'M', // magic
+		1, 0, // version
+		2,                      // depth
+		0,                      // reserved
+		4, 0, 0, 0, 0, 0, 0, 0, // total count
+		3, 0, 0, 0, // entries
+		0, 0, 0, 0, // reserved
+		// offsets of the 8 blocks, and the end
+		0, 0, 0, 0,
+		1, 0, 0, 0,
+		1, 0, 0, 0,
+		1, 0, 0, 0,
+		1, 0, 0, 0,
+		3, 0, 0, 0,
+		3, 0, 0, 0,
+		3, 0, 0, 0,
+		3, 0, 0, 0,
+		// entries
+		0x01, 0x02, 0x03, 0, 1, 0, 0, 0, 0, 0, 0, 0,
+		0x80, 0x00, 0x01, 0, 1, 0, 0, 0, 0, 0, 0, 0,
+		0xFF, 0x00, 0x00, 0, 2, 0, 0, 0, 0, 0, 0, 0,
+	})
+}
+
+func (*MappedSuite) TestFindClosest(c *check.C) {
+	oct, err := NewOctree(5)
+	c.Assert(err, check.IsNil)
+	m, err := NewMappedOctree(writeMapped(c, oct))
+	c.Assert(err, check.IsNil)
+	c.Check(m.FindClosest(1, 2, 3), check.DeepEquals, value{})
+	oct.Add(0, 0, 0)
+	oct.Add(0xFF, 0, 0)
+	oct.Add(0, 0xFF, 0)
+	oct.Add(0, 0, 0xFF)
+	m, err = NewMappedOctree(writeMapped(c, oct))
+	c.Assert(err, check.IsNil)
+	c.Check(m.Depth(), check.Equals, 5)
+	c.Check(m.FindClosest(0, 0, 1), check.DeepEquals, value{count: 1})
+	c.Check(m.FindClosest(0xE0, 0, 0), check.DeepEquals, value{r: 0xFF, count: 1})
+	c.Check(m.FindClosest(0x80, 0x80, 0xFF), check.DeepEquals, value{b: 0xFF, count: 1})
+}
+
+func (*MappedSuite) TestFindClosestMatchesOctree(c *check.C) {
+	rng := rand.New(rand.NewSource(1))
+	for _, depth := range []int{1, 3, 6, 9} {
+		oct, err := NewOctree(depth)
+		c.Assert(err, check.IsNil)
+		for i := 0; i < 100; i++ {
+			oct.Add(uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)))
+		}
+		m, err := NewMappedOctree(writeMapped(c, oct))
+		c.Assert(err, check.IsNil)
+		c.Check(m.Depth(), check.Equals, min(depth, 7))
+		for i := 0; i < 200; i++ {
+			r, g, b := uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256))
+			expected := oct.FindClosest(r, g, b)
+			found := m.FindClosest(r, g, b)
+			c.Check(dist2ToV(r, g, b, &found), check.Equals, dist2ToV(r, g, b, &expected))
+		}
+	}
+}
+
+func (*MappedSuite) TestOpenMappedOctree(c *check.C) {
+	oct, err := NewOctree(4)
+	c.Assert(err, check.IsNil)
+	oct.Add(0x10, 0x20, 0x30)
+	oct.Add(0xF0, 0xE0, 0xD0)
+	path := filepath.Join(c.MkDir(), "palette.octm")
+	c.Assert(os.WriteFile(path, writeMapped(c, oct), 0644), check.IsNil)
+	m, err := OpenMappedOctree(path)
+	c.Assert(err, check.IsNil)
+	c.Check(m.FindClosest(0xFF, 0xFF, 0xFF), check.DeepEquals,
+		value{r: 0xF0, g: 0xE0, b: 0xD0, count: 1})
+	c.Check(m.Close(), check.IsNil)
+	c.Check(m.Close(), check.IsNil)
+
+	empty := filepath.Join(c.MkDir(), "empty.octm")
+	c.Assert(os.WriteFile(empty, nil, 0644), check.IsNil)
+	_, err = OpenMappedOctree(empty)
+	c.Check(err, check.ErrorMatches,
+		".*empty.octm: Truncated mapped octree: 0 bytes is too short for the header")
+	_, err = OpenMappedOctree(filepath.Join(c.MkDir(), "missing"))
+	c.Check(err, check.ErrorMatches, ".*no such file or directory")
+}
+
+func (*MappedSuite) TestNewMappedOctreeErrors(c *check.C) {
+	oct, err := NewOctree(2)
+	c.Assert(err, check.IsNil)
+	oct.Add(0xFF, 0x00, 0x00)
+	oct.Add(0x01, 0x02, 0x03)
+	good := writeMapped(c, oct)
+	corrupt := func(f func(data []byte) []byte) []byte {
+		return f(append([]byte(nil), good...))
+	}
+	for _, test := range []struct {
+		data []byte
+		err  string
+	}{{
+		data: good[:20],
+		err:  "Truncated mapped octree: 20 bytes is too short for the header",
+	}, {
+		data: corrupt(func(d []byte) []byte { d[3] = 'X'; return d }),
+		err:  `Invalid mapped octree: bad magic "OCTX"`,
+	}, {
+		data: corrupt(func(d []byte) []byte { d[4] = 3; return d }),
+		err:  "Unsupported mapped octree version: 3",
+	}, {
+		data: corrupt(func(d []byte) []byte { d[6] = 8; return d }),
+		err:  "Invalid mapped octree: invalid depth 8",
+	}, {
+		data: corrupt(func(d []byte) []byte { d[21] = 1; return d }),
+		err:  "Invalid mapped octree: reserved bytes are not 0",
+	}, {
+		data: good[:len(good)-1],
+		err:  "Invalid mapped octree: 2 entries at depth 2 needs 84 bytes, have 83",
+	}, {
+		data: corrupt(func(d []byte) []byte {
+			binary.LittleEndian.PutUint32(d[28:], 2)
+			return d
+		}),
+		err: "Invalid mapped octree: bad offset 1 for block 2",
+	}, {
+		data: corrupt(func(d []byte) []byte {
+			binary.LittleEndian.PutUint32(d[24:], 1)
+			return d
+		}),
+		err: "Invalid mapped octree: offsets don't cover the 2 entries",
+	}} {
+		m, err := NewMappedOctree(test.data)
+		c.Check(err, check.ErrorMatches, test.err)
+		c.Check(m, check.IsNil)
+	}
+}
@@ -0,0 +1,64 @@
+package octree
+
+import (
+	"container/heap"
+	"slices"
+)
+
+// Split the values into at most n boxes by median cut. The box with the
+// largest error, measured with metric, is split next, across whichever
+// channel its colors spread furthest along, so that each half has about half
+// of its count.
+func medianCut(values []*value, n int, metric Metric) []*quantizeGroup {
+	var final []*quantizeGroup
+	boxes := quantizeHeap{newQuantizeGroup(metric, values...)}
+	for len(boxes) > 0 {
+		g := heap.Pop(&boxes).(*quantizeGroup)
+		if len(g.values) < 2 || len(final)+len(boxes)+2 > n {
+			final = append(final, g)
+			continue
+		}
+		low, high := splitMedian(g.values)
+		heap.Push(&boxes, newQuantizeGroup(metric, low...))
+		heap.Push(&boxes, newQuantizeGroup(metric, high...))
+	}
+	return final
+}
+
+// Sort values along their widest channel, and split them where the count of
+// the first half is closest to half of the total. values has at least 2
+// distinct colors, so neither half is empty.
+func splitMedian(values []*value) (low, high []*value) {
+	channels := [3]func(v *value) uint8{
+		func(v *value) uint8 { return v.r },
+		func(v *value) uint8 { return v.g },
+		func(v *value) uint8 { return v.b },
+	}
+	widest, widestRange := 0, -1
+	for i, channel := range channels {
+		lo, hi := uint8(255), uint8(0)
+		for _, v := range values {
+			lo, hi = min(lo, channel(v)), max(hi, channel(v))
+		}
+		if int(hi)-int(lo) > widestRange {
+			widest, widestRange = i, int(hi)-int(lo)
+		}
+	}
+	slices.SortFunc(values, func(x, y *value) int {
+		if c := int(channels[widest](x)) - int(channels[widest](y)); c != 0 {
+			return c
+		}
+		// Keep the order the same every time
+		return int(interleaveRGB(x.r, x.g, x.b)) - int(interleaveRGB(y.r, y.g, y.b))
+	})
+	total := uint64(0)
+	for _, v := range values {
+		total += v.count
+	}
+	split, sum := 1, values[0].count
+	for split < len(values)-1 && 2*sum+values[split].count < total {
+		sum += values[split].count
+		split++
+	}
+	return values[:split], values[split:]
+}
@@ -0,0 +1,52 @@
+package octree
+
+import (
+	"gopkg.in/check.v1"
+)
+
+type MedianCutSuite struct{}
+
+var _ = check.Suite(&MedianCutSuite{})
+
+func (*MedianCutSuite) TestSplitMedian(c *check.C) {
+	values := []*value{
+		{r: 0x80, g: 0x10, b: 0x00, count: 1},
+		{r: 0x80, g: 0x00, b: 0x00, count: 2},
+		{r: 0x80, g: 0x40, b: 0x08, count: 1},
+		{r: 0x80, g: 0x20, b: 0x00, count: 4},
+	}
+	greens := func(values []*value) []uint8 {
+		var greens []uint8
+		for _, v := range values {
+			greens = append(greens, v.g)
+		}
+		return greens
+	}
+	// Green is the widest, and 3 of the 8 is closer to half than 7
+	low, high := splitMedian(values)
+	c.Check(greens(low), check.DeepEquals, []uint8{0x00, 0x10})
+	c.Check(greens(high), check.DeepEquals, []uint8{0x20, 0x40})
+	// Neither half is ever empty
+	low, high = splitMedian([]*value{{r: 1, count: 100}, {r: 2, count: 1}})
+	c.Check(low, check.HasLen, 1)
+	c.Check(high, check.HasLen, 1)
+}
+
+// Unlike Quantize, median cut splits by count, so a rare color far from the
+// rest gets merged into them.
+func (*MedianCutSuite) TestMedianCutSplitsByCount(c *check.C) {
+	oct, err := NewOctree(4)
+	c.Assert(err, check.IsNil)
+	for i := 0; i < 100; i++ {
+		oct.Add(0, 0, uint8(i%4))
+	}
+	oct.Add(0xFF, 0, 0)
+	oct.Add(0xFF, 0xFF, 0)
+	palette, err := oct.QuantizeWith(MedianCutQuantizer, 3, EuclideanMetric)
+	c.Assert(err, check.IsNil)
+	c.Check(palette, check.DeepEquals, []PaletteColor{
+		{R: 0, G: 0, B: 1, Count: 50},
+		{R: 19, G: 9, B: 3, Count: 27},
+		{R: 0, G: 0, B: 2, Count: 25},
+	})
+}
@@ -0,0 +1,52 @@
+package octree
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Metric selects how the difference between two colors is measured when
+// quantizing.
+type Metric int
+
+const (
+	// EuclideanMetric is the squared straight line distance in RGB space,
+	// the same distance that FindClosest uses.
+	EuclideanMetric Metric = iota
+	// RedmeanMetric weights the channels by how sensitive the eye is to
+	// them, with the red and blue weights depending on how red the colors
+	// are. It is a cheap approximation of a perceptual distance.
+	RedmeanMetric
+)
+
+func (m Metric) String() string {
+	switch m {
+	case EuclideanMetric:
+		return "euclidean"
+	case RedmeanMetric:
+		return "redmean"
+	}
+	return fmt.Sprintf("Metric(%d)", int(m))
+}
+
+// ParseMetric finds the Metric with the given name, as returned by String.
+func ParseMetric(name string) (Metric, error) {
+	switch strings.ToLower(name) {
+	case "euclidean":
+		return EuclideanMetric, nil
+	case "redmean":
+		return RedmeanMetric, nil
+	}
+	return 0, fmt.Errorf("Unknown metric: %q", name)
+}
+
+// The squared distance between two colors. Channels are float64 so that
+// means of many colors can be compared without rounding them.
+func (m Metric) dist2(r1, g1, b1, r2, g2, b2 float64) float64 {
+	dr, dg, db := r1-r2, g1-g2, b1-b2
+	if m == RedmeanMetric {
+		rMean := (r1 + r2) / 2
+		return (2+rMean/256)*dr*dr + 4*dg*dg + (2+(255-rMean)/256)*db*db
+	}
+	return dr*dr + dg*dg + db*db
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package octree
+
+// Without mmap, read the whole file instead.
+func mapFile(path string) ([]byte, func() error, error) {
+	return readFile(path)
+}
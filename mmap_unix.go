@@ -0,0 +1,33 @@
+//go:build unix
+
+package octree
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Map the whole file read-only. The returned function unmaps it again.
+func mapFile(path string) ([]byte, func() error, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	size := info.Size()
+	if size == 0 || int64(int(size)) != size {
+		// mmap can't map an empty file, let the caller report it as
+		// truncated. Files too big to map can't be valid anyway.
+		return readFile(path)
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cannot map %s: %v", path, err)
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
@@ -0,0 +1,116 @@
+package octree
+
+import (
+	"math/bits"
+)
+
+// These masks select the bits that belong to each channel of an index created
+// by interleaveRGB. b is in the lowest bit of every triple, then g, then r.
+const (
+	mortonMaskB = uint32(0x249249)
+	mortonMaskG = mortonMaskB << 1
+	mortonMaskR = mortonMaskB << 2
+)
+
+// Add one to the channel selected by mask without decoding the index. All of
+// the bits that don't belong to the channel are set to 1, so the carry out of
+// each channel bit ripples straight through them into the next channel bit.
+// The other channels are left untouched. Incrementing a channel that is
+// already at its maximum wraps it back to 0.
+func mortonInc(index, mask uint32) uint32 {
+	return ((index|^mask)+1)&mask | index&^mask
+}
+
+// Subtract one from the channel selected by mask without decoding the index.
+// All of the bits that don't belong to the channel are cleared, so the borrow
+// ripples straight through them. Decrementing a channel that is already 0
+// wraps it around to its maximum.
+func mortonDec(index, mask uint32) uint32 {
+	return ((index&mask)-1)&mask | index&^mask
+}
+
+// Find the channel selected by mask, along with the values one less and one
+// greater, in increasing order. Only the bits of the channel are returned, so
+// the result for each channel can be OR'd together. mask should already be
+// restricted to the bits in use, so that neighbors past the edge are dropped
+// rather than wrapping around.
+func mortonAxisNeighbors(index, mask uint32) []uint32 {
+	v := index & mask
+	neighbors := make([]uint32, 0, 3)
+	if v != 0 {
+		neighbors = append(neighbors, mortonDec(v, mask))
+	}
+	neighbors = append(neighbors, v)
+	if v != mask {
+		neighbors = append(neighbors, mortonInc(v, mask))
+	}
+	return neighbors
+}
+
+// A contiguous, inclusive run [start, end] of interleaved indexes.
+type mortonRange struct {
+	start, end uint32
+}
+
+// Convert the inclusive box [vMin, vMax] into the smallest ordered set of
+// contiguous Morton ranges that together hold exactly the indexes inside the
+// box. The coordinates don't have to be full 8-bit colors, block coordinates
+// work just as well, since the high bits are simply 0.
+func mortonBoxRanges(vMin, vMax value) []mortonRange {
+	if vMin.r > vMax.r || vMin.g > vMax.g || vMin.b > vMax.b {
+		return nil
+	}
+	return appendMortonBoxRanges(nil, vMin, vMax)
+}
+
+func appendMortonBoxRanges(ranges []mortonRange, vMin, vMax value) []mortonRange {
+	zMin := interleaveRGB(vMin.r, vMin.g, vMin.b)
+	zMax := interleaveRGB(vMax.r, vMax.g, vMax.b)
+	volume := (uint32(vMax.r-vMin.r) + 1) *
+		(uint32(vMax.g-vMin.g) + 1) *
+		(uint32(vMax.b-vMin.b) + 1)
+	if zMax-zMin+1 == volume {
+		// Every index between the two corners is inside the box, so this
+		// is a single run. Join it to the previous run if they touch.
+		if n := len(ranges); n > 0 && ranges[n-1].end+1 == zMin {
+			ranges[n-1].end = zMax
+			return ranges
+		}
+		return append(ranges, mortonRange{start: zMin, end: zMax})
+	}
+	litMax, bigMin := mortonLitMaxBigMin(vMin, vMax)
+	ranges = appendMortonBoxRanges(ranges, vMin, litMax)
+	return appendMortonBoxRanges(ranges, bigMin, vMax)
+}
+
+// Split the box [vMin, vMax] at the highest bit where the Morton indexes of
+// its corners differ. LITMAX is the largest index inside the box that is
+// below the split, and BIGMIN is the smallest index inside the box that is
+// above it. Everything in the box is either <= LITMAX or >= BIGMIN, and
+// nothing between them is inside the box. (Tropf & Herzog, 1981)
+// The box must not be a single point.
+func mortonLitMaxBigMin(vMin, vMax value) (litMax, bigMin value) {
+	zMin := interleaveRGB(vMin.r, vMin.g, vMin.b)
+	zMax := interleaveRGB(vMax.r, vMax.g, vMax.b)
+	bit := uint(bits.Len32(zMin^zMax) - 1)
+	litMax, bigMin = vMax, vMin
+	// The corners agree on every bit above 'bit', so in the channel that
+	// owns it, vMin has a 0 there and vMax has a 1.
+	shift := bit / 3
+	switch bit % 3 {
+	case 0:
+		litMax.b, bigMin.b = splitChannel(vMin.b, vMax.b, shift)
+	case 1:
+		litMax.g, bigMin.g = splitChannel(vMin.g, vMax.g, shift)
+	case 2:
+		litMax.r, bigMin.r = splitChannel(vMin.r, vMax.r, shift)
+	}
+	return litMax, bigMin
+}
+
+// Split the channel range [lo, hi] at the given bit, returning the largest
+// value with that bit clear and the smallest value with that bit set.
+func splitChannel(lo, hi uint8, shift uint) (loMax, hiMin uint8) {
+	bit := uint8(1) << shift
+	return hi&^bit | (bit - 1), lo&^(bit-1) | bit
+}
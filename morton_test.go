@@ -0,0 +1,152 @@
+package octree
+
+import (
+	"gopkg.in/check.v1"
+)
+
+type MortonSuite struct{}
+
+var _ = check.Suite(&MortonSuite{})
+
+func checkMortonStep(c *check.C, r, g, b uint8) {
+	index := interleaveRGB(r, g, b)
+	if r < 0xFF {
+		c.Check(mortonInc(index, mortonMaskR), check.Equals,
+			interleaveRGB(r+1, g, b))
+	}
+	if g < 0xFF {
+		c.Check(mortonInc(index, mortonMaskG), check.Equals,
+			interleaveRGB(r, g+1, b))
+	}
+	if b < 0xFF {
+		c.Check(mortonInc(index, mortonMaskB), check.Equals,
+			interleaveRGB(r, g, b+1))
+	}
+	if r > 0 {
+		c.Check(mortonDec(index, mortonMaskR), check.Equals,
+			interleaveRGB(r-1, g, b))
+	}
+	if g > 0 {
+		c.Check(mortonDec(index, mortonMaskG), check.Equals,
+			interleaveRGB(r, g-1, b))
+	}
+	if b > 0 {
+		c.Check(mortonDec(index, mortonMaskB), check.Equals,
+			interleaveRGB(r, g, b-1))
+	}
+}
+
+func (*MortonSuite) TestMortonIncDec(c *check.C) {
+	checkMortonStep(c, 0x00, 0x00, 0x00)
+	checkMortonStep(c, 0xFF, 0xFF, 0xFF)
+	checkMortonStep(c, 0x7F, 0x80, 0x0F)
+	checkMortonStep(c, 0x80, 0x7F, 0xF0)
+	for r := 0; r <= 0xFF; r += 7 {
+		for g := 0; g <= 0xFF; g += 5 {
+			for b := 0; b <= 0xFF; b += 3 {
+				checkMortonStep(c, uint8(r), uint8(g), uint8(b))
+			}
+		}
+	}
+}
+
+func (*MortonSuite) TestMortonWraps(c *check.C) {
+	c.Check(mortonInc(interleaveRGB(0xFF, 0x12, 0x34), mortonMaskR),
+		check.Equals, interleaveRGB(0x00, 0x12, 0x34))
+	c.Check(mortonDec(interleaveRGB(0x12, 0x00, 0x34), mortonMaskG),
+		check.Equals, interleaveRGB(0x12, 0xFF, 0x34))
+}
+
+func (*MortonSuite) TestMortonAxisNeighbors(c *check.C) {
+	// With 2 layers, each axis only has 2 bits (0-3)
+	mask := mortonMaskB & 0x3f
+	c.Check(mortonAxisNeighbors(0x00, mask), check.DeepEquals,
+		[]uint32{0x00, 0x01})
+	c.Check(mortonAxisNeighbors(0x01, mask), check.DeepEquals,
+		[]uint32{0x00, 0x01, 0x08})
+	c.Check(mortonAxisNeighbors(0x3f, mask), check.DeepEquals,
+		[]uint32{0x08, 0x09})
+	// Only the bits from the requested axis are returned
+	c.Check(mortonAxisNeighbors(0x3f, mortonMaskR&0x3f), check.DeepEquals,
+		[]uint32{0x20, 0x24})
+}
+
+// Check that the ranges hold exactly the indexes inside the box, and that
+// no two ranges could have been joined.
+func checkMortonBoxRanges(c *check.C, vMin, vMax value) {
+	ranges := mortonBoxRanges(vMin, vMax)
+	inBox := 0
+	for r := int(vMin.r); r <= int(vMax.r); r++ {
+		for g := int(vMin.g); g <= int(vMax.g); g++ {
+			for b := int(vMin.b); b <= int(vMax.b); b++ {
+				inBox++
+				index := interleaveRGB(uint8(r), uint8(g), uint8(b))
+				found := false
+				for _, run := range ranges {
+					if index >= run.start && index <= run.end {
+						found = true
+						break
+					}
+				}
+				c.Assert(found, check.Equals, true,
+					check.Commentf("%d,%d,%d not in %v", r, g, b, ranges))
+			}
+		}
+	}
+	total := 0
+	for i, run := range ranges {
+		c.Assert(run.start <= run.end, check.Equals, true)
+		if i > 0 {
+			c.Assert(ranges[i-1].end+1 < run.start, check.Equals, true,
+				check.Commentf("ranges not minimal: %v", ranges))
+		}
+		total += int(run.end-run.start) + 1
+	}
+	c.Check(total, check.Equals, inBox)
+}
+
+func (*MortonSuite) TestMortonBoxRangesSingleRun(c *check.C) {
+	// An aligned cube is always a single run
+	c.Check(mortonBoxRanges(value{}, value{r: 3, g: 3, b: 3}),
+		check.DeepEquals, []mortonRange{{start: 0, end: 63}})
+	c.Check(mortonBoxRanges(value{r: 4, g: 4, b: 4}, value{r: 7, g: 7, b: 7}),
+		check.DeepEquals, []mortonRange{{start: 0x1c0, end: 0x1ff}})
+	c.Check(mortonBoxRanges(value{r: 5, g: 6, b: 7}, value{r: 5, g: 6, b: 7}),
+		check.DeepEquals, []mortonRange{{start: 0x1dd, end: 0x1dd}})
+	c.Check(mortonBoxRanges(value{}, value{r: 0xFF, g: 0xFF, b: 0xFF}),
+		check.DeepEquals, []mortonRange{{start: 0, end: 0xFFFFFF}})
+}
+
+func (*MortonSuite) TestMortonBoxRangesSplit(c *check.C) {
+	// b in [0,1], g in [0, 1], r in [1, 2] is 2 aligned pairs that aren't
+	// next to each other.
+	c.Check(mortonBoxRanges(value{r: 1}, value{r: 2, g: 1, b: 1}),
+		check.DeepEquals, []mortonRange{
+			{start: 0x04, end: 0x07},
+			{start: 0x20, end: 0x23},
+		})
+	c.Check(mortonBoxRanges(value{r: 2}, value{r: 1}), check.IsNil)
+}
+
+func (*MortonSuite) TestMortonBoxRangesExhaustive(c *check.C) {
+	checkMortonBoxRanges(c, value{r: 1, g: 2, b: 3}, value{r: 6, g: 5, b: 9})
+	checkMortonBoxRanges(c, value{r: 0, g: 7, b: 0}, value{r: 8, g: 8, b: 15})
+	checkMortonBoxRanges(c, value{r: 3, g: 3, b: 3}, value{r: 3, g: 12, b: 3})
+	checkMortonBoxRanges(c, value{r: 0x7E, g: 0x10, b: 0xF0},
+		value{r: 0x81, g: 0x1F, b: 0xFF})
+	for lo := 0; lo < 8; lo++ {
+		for hi := lo; hi < 12; hi++ {
+			checkMortonBoxRanges(c,
+				value{r: uint8(lo), g: uint8(hi - lo), b: 1},
+				value{r: uint8(hi), g: uint8(hi), b: uint8(hi + 1)})
+		}
+	}
+}
+
+func (*MortonSuite) TestMortonLitMaxBigMin(c *check.C) {
+	// The example box from Tropf & Herzog, in 2 of our 3 dimensions
+	litMax, bigMin := mortonLitMaxBigMin(
+		value{r: 3, g: 5, b: 0}, value{r: 5, g: 10, b: 0})
+	c.Check(litMax, check.DeepEquals, value{r: 5, g: 7, b: 0})
+	c.Check(bigMin, check.DeepEquals, value{r: 3, g: 8, b: 0})
+}
@@ -0,0 +1,221 @@
+package octree
+
+// NodeStats summarizes the colors inside one block of the tree.
+type NodeStats struct {
+	// The total count of the colors
+	Count uint64
+	// The mean of r, g and b, weighted by count
+	Mean [3]float64
+	// The population variance of r, g and b, weighted by count
+	Variance [3]float64
+	// The tight inclusive bounds of the colors, as r, g, b
+	Min, Max [3]uint8
+}
+
+// The running totals of the colors in one block, kept by trees built with
+// WithNodeStats.
+type nodeTotals struct {
+	count uint64
+	// Sums of r, g and b, and of their squares, each times its count. With
+	// WithCounts64 the sums of squares can wrap past about 2^48 colors,
+	// as each term is at most 255^2 times its count.
+	sum, sumSq [3]uint64
+	// Only meaningful when count is not 0
+	min, max [3]uint8
+}
+
+func (t *nodeTotals) add(r, g, b uint8, count uint64) {
+	for i, v := range [3]uint8{r, g, b} {
+		t.sum[i] += uint64(v) * count
+		t.sumSq[i] += uint64(v) * uint64(v) * count
+		if t.count == 0 || v < t.min[i] {
+			t.min[i] = v
+		}
+		if t.count == 0 || v > t.max[i] {
+			t.max[i] = v
+		}
+	}
+	t.count += count
+}
+
+// Remove count copies of r,g,b. The bounds are left alone, as only the caller
+// knows whether r,g,b is still held, so it has to refit them if not.
+func (t *nodeTotals) remove(r, g, b uint8, count uint64) {
+	t.count -= count
+	if t.count == 0 {
+		*t = nodeTotals{}
+		return
+	}
+	for i, v := range [3]uint8{r, g, b} {
+		t.sum[i] -= uint64(v) * count
+		t.sumSq[i] -= uint64(v) * uint64(v) * count
+	}
+}
+
+// Add in the totals of another block.
+func (t *nodeTotals) combine(other *nodeTotals) {
+	if other.count == 0 {
+		return
+	}
+	for i := range t.sum {
+		t.sum[i] += other.sum[i]
+		t.sumSq[i] += other.sumSq[i]
+		if t.count == 0 || other.min[i] < t.min[i] {
+			t.min[i] = other.min[i]
+		}
+		if t.count == 0 || other.max[i] > t.max[i] {
+			t.max[i] = other.max[i]
+		}
+	}
+	t.count += other.count
+}
+
+// Shrink or grow the bounds to fit exactly the bounds of others.
+func (t *nodeTotals) fitBounds(others []*nodeTotals) {
+	first := true
+	for _, other := range others {
+		if other.count == 0 {
+			continue
+		}
+		for i := range t.min {
+			if first || other.min[i] < t.min[i] {
+				t.min[i] = other.min[i]
+			}
+			if first || other.max[i] > t.max[i] {
+				t.max[i] = other.max[i]
+			}
+		}
+		first = false
+	}
+}
+
+// Set the bounds to fit exactly the colors of a leaf.
+func (t *nodeTotals) fitValues(values []*value) {
+	for i, v := range values {
+		for j, c := range [3]uint8{v.r, v.g, v.b} {
+			if i == 0 || c < t.min[j] {
+				t.min[j] = c
+			}
+			if i == 0 || c > t.max[j] {
+				t.max[j] = c
+			}
+		}
+	}
+}
+
+func (t *nodeTotals) stats() NodeStats {
+	stats := NodeStats{Count: t.count, Min: t.min, Max: t.max}
+	if t.count == 0 {
+		return stats
+	}
+	n := float64(t.count)
+	for i := range t.sum {
+		mean := float64(t.sum[i]) / n
+		stats.Mean[i] = mean
+		stats.Variance[i] = max(float64(t.sumSq[i])/n-mean*mean, 0)
+	}
+	return stats
+}
+
+func (n *node) addTotals(r, g, b uint8, count uint64) {
+	if n.totals != nil {
+		n.totals.add(r, g, b, count)
+	}
+}
+
+// The totals of the block at c, or nil if the tree doesn't keep them.
+func (o *Octree) cursorTotals(c cursor) *nodeTotals {
+	if c.n != nil {
+		return c.n.totals
+	}
+	if o.layerTotals == nil {
+		return nil
+	}
+	return &o.layerTotals[c.level][c.index]
+}
+
+// The inclusive bounds that the colors in the block at c lie within. These
+// are the tight bounds of the colors if the tree keeps totals, or the bounds
+// of the block if not.
+func (o *Octree) cursorBounds(c cursor) (vMin, vMax value) {
+	if t := o.cursorTotals(c); t != nil && t.count > 0 {
+		vMin = value{r: t.min[0], g: t.min[1], b: t.min[2]}
+		vMax = value{r: t.max[0], g: t.max[1], b: t.max[2]}
+		return vMin, vMax
+	}
+	return o.cursorMinMax(c)
+}
+
+// Take count copies of r,g,b out of the totals of a dense tree. valueSlice
+// is what the leaf holds afterwards.
+func (o *Octree) removeDenseTotals(r, g, b uint8, index uint32, count uint64, valueSlice []*value) {
+	for level, totals := range o.layerTotals {
+		totals[index>>uint(24-level*3)].remove(r, g, b, count)
+	}
+	if containsColor(valueSlice, r, g, b) {
+		return
+	}
+	// r,g,b is gone, so it may have been holding up some bounds
+	leafLevel := len(o.layerTotals) - 1
+	leafIndex := index >> uint(24-leafLevel*3)
+	o.layerTotals[leafLevel][leafIndex].fitValues(valueSlice)
+	for level := leafLevel - 1; level >= 0; level-- {
+		parent := index >> uint(24-level*3)
+		var children []*nodeTotals
+		for i := uint32(0); i < 8; i++ {
+			children = append(children, &o.layerTotals[level+1][parent<<3|i])
+		}
+		o.layerTotals[level][parent].fitBounds(children)
+	}
+}
+
+func containsColor(values []*value, r, g, b uint8) bool {
+	for _, v := range values {
+		if v.r == r && v.g == g && v.b == b {
+			return true
+		}
+	}
+	return false
+}
+
+// Refit the bounds along the path from the root to the leaf that a color
+// was just removed from.
+func (o *Octree) refitSparseTotals(path []*node) {
+	for i := len(path) - 1; i >= 0; i-- {
+		n := path[i]
+		if n.count == 0 {
+			continue
+		}
+		if n.children == nil {
+			n.totals.fitValues(n.values)
+			continue
+		}
+		var children []*nodeTotals
+		for _, child := range n.children {
+			if child != nil {
+				children = append(children, child.totals)
+			}
+		}
+		n.totals.fitBounds(children)
+	}
+}
+
+// NodeStats summarizes the colors inside the block described by n. Only
+// n.Level and n.Index are used. It returns false if the block is empty, or
+// isn't in the tree. Trees built with WithNodeStats answer from their totals,
+// others have to walk the colors of the block.
+func (o *Octree) NodeStats(n Node) (NodeStats, bool) {
+	if n.Level < 0 || n.Level >= o.depth || uint64(n.Index) >= 1<<(3*uint(n.Level)) {
+		return NodeStats{}, false
+	}
+	if c, ok := o.findCursor(uint(n.Level), n.Index); ok && c.level == uint(n.Level) {
+		if t := o.cursorTotals(c); t != nil {
+			return t.stats(), true
+		}
+	}
+	var t nodeTotals
+	for v := range o.NodeEntries(n) {
+		t.add(v.r, v.g, v.b, v.count)
+	}
+	return t.stats(), t.count > 0
+}
@@ -0,0 +1,118 @@
+package octree
+
+import (
+	"math"
+	"math/rand"
+
+	"gopkg.in/check.v1"
+)
+
+type NodeStatsSuite struct{}
+
+var _ = check.Suite(&NodeStatsSuite{})
+
+var nodeStatsOptions = [][]Option{
+	nil,
+	{WithOrdering(HilbertOrder)},
+	{WithStorage(SparseStorage)},
+	{WithAdaptiveLeaves(4)},
+}
+
+func (*NodeStatsSuite) TestNodeStats(c *check.C) {
+	for _, options := range nodeStatsOptions {
+		oct, err := NewOctree(3, append(options, WithNodeStats())...)
+		c.Assert(err, check.IsNil)
+		_, ok := oct.NodeStats(Node{})
+		c.Check(ok, check.Equals, false)
+		oct.Add(0x10, 0x20, 0x30)
+		oct.Add(0x10, 0x20, 0x30)
+		oct.Add(0x30, 0x20, 0x10)
+		oct.Add(0x30, 0x28, 0x00)
+		stats, ok := oct.NodeStats(Node{})
+		c.Assert(ok, check.Equals, true)
+		c.Check(stats, check.DeepEquals, NodeStats{
+			Count:    4,
+			Mean:     [3]float64{0x20, 0x22, 0x1C},
+			Variance: [3]float64{0x100, 12, 432},
+			Min:      [3]uint8{0x10, 0x20, 0x00},
+			Max:      [3]uint8{0x30, 0x28, 0x30},
+		})
+		// Removing the only color on a bound shrinks it
+		c.Check(oct.Remove(0x30, 0x28, 0x00), check.Equals, true)
+		stats, ok = oct.NodeStats(Node{})
+		c.Assert(ok, check.Equals, true)
+		c.Check(stats.Min, check.Equals, [3]uint8{0x10, 0x20, 0x10})
+		c.Check(stats.Max, check.Equals, [3]uint8{0x30, 0x20, 0x30})
+		_, ok = oct.NodeStats(Node{Level: 1, Index: 7})
+		c.Check(ok, check.Equals, false)
+		_, ok = oct.NodeStats(Node{Level: 3})
+		c.Check(ok, check.Equals, false)
+	}
+}
+
+// Trees that keep totals have to agree with trees that walk their colors,
+// for every block, after any mix of adds and removes.
+func (*NodeStatsSuite) TestNodeStatsMatchWalk(c *check.C) {
+	rng := rand.New(rand.NewSource(1))
+	for _, options := range nodeStatsOptions {
+		oct, err := NewOctree(4, append(options, WithNodeStats())...)
+		c.Assert(err, check.IsNil)
+		walked, err := NewOctree(4, options...)
+		c.Assert(err, check.IsNil)
+		var added [][3]uint8
+		for i := 0; i < 2000; i++ {
+			if len(added) > 0 && rng.Intn(3) == 0 {
+				j := rng.Intn(len(added))
+				rgb := added[j]
+				added = append(added[:j], added[j+1:]...)
+				c.Assert(oct.Remove(rgb[0], rgb[1], rgb[2]), check.Equals, true)
+				walked.Remove(rgb[0], rgb[1], rgb[2])
+				continue
+			}
+			// Few enough colors that they repeat
+			rgb := [3]uint8{uint8(rng.Intn(8) * 32), uint8(rng.Intn(8) * 32), uint8(rng.Intn(256))}
+			added = append(added, rgb)
+			oct.Add(rgb[0], rgb[1], rgb[2])
+			walked.Add(rgb[0], rgb[1], rgb[2])
+		}
+		for level := 0; level < 4; level++ {
+			for n := range walked.Nodes(level) {
+				expected, ok := walked.NodeStats(n)
+				c.Assert(ok, check.Equals, true)
+				c.Check(expected.Count, check.Equals, n.Count)
+				stats, ok := oct.NodeStats(n)
+				c.Assert(ok, check.Equals, true)
+				c.Check(stats.Count, check.Equals, expected.Count)
+				c.Check(stats.Min, check.Equals, expected.Min)
+				c.Check(stats.Max, check.Equals, expected.Max)
+				for i := range stats.Mean {
+					c.Check(math.Abs(stats.Mean[i]-expected.Mean[i]) < 1e-9, check.Equals, true)
+					c.Check(math.Abs(stats.Variance[i]-expected.Variance[i]) < 1e-6, check.Equals, true)
+				}
+			}
+		}
+	}
+}
+
+// Searches prune by the tight bounds, which mustn't change what they find.
+func (*NodeStatsSuite) TestSearchesWithNodeStats(c *check.C) {
+	rng := rand.New(rand.NewSource(2))
+	for _, options := range nodeStatsOptions {
+		oct, err := NewOctree(4, append(options, WithNodeStats())...)
+		c.Assert(err, check.IsNil)
+		plain, err := NewOctree(4, options...)
+		c.Assert(err, check.IsNil)
+		for i := 0; i < 300; i++ {
+			r, g, b := uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256))
+			oct.Add(r, g, b)
+			plain.Add(r, g, b)
+		}
+		for i := 0; i < 100; i++ {
+			r, g, b := uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256))
+			found := oct.FindClosest(r, g, b)
+			expected := plain.FindClosest(r, g, b)
+			c.Check(dist2ToV(r, g, b, &found), check.Equals, dist2ToV(r, g, b, &expected))
+			c.Check(oct.FindWithin(r, g, b, 40), check.DeepEquals, plain.FindWithin(r, g, b, 40))
+		}
+	}
+}
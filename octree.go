@@ -2,66 +2,312 @@ package octree
 
 import (
 	"fmt"
+	"slices"
 )
 
 // Track the counts of everything in an 8-way structure.  The deeper 'depth' is
 // set, the more memory is consumed, but the finer grained the counting is.
 // This also maps the keys to a concrete object at the lowest layer
 type Octree struct {
-	count uint32
+	count uint64
 	// Each layer has 8^n count fields
 	layerCounts [][]uint32
+	// Dense trees built with WithCounts64 use these instead of layerCounts
+	layerCounts64 [][]uint64
+	// count never goes past this, so that no count in the tree can wrap
+	maxCount uint64
+	// Whether any adds were dropped because count reached maxCount
+	saturated bool
 	// The last layer maps to a sparse slice of values.
 	values [][]*value
+	// Sparse trees leave layerCounts and values nil, and only allocate the
+	// nodes that have been populated, starting from root.
+	root *node
+	// The number of levels including the root, so the leaves are at
+	// depth-1.
+	depth int
+	// When non-zero, leaves of a sparse tree start at the root and split
+	// once they hold more than this many values, rather than all being at
+	// depth-1. depth is then the deepest that a leaf can be.
+	maxLeafSize int
+	// How blocks are laid out in layerCounts and values
+	ordering Ordering
+	// With WithNodeStats, the running totals of every block of a dense tree,
+	// with the root at level 0 down to the leaves. Sparse trees keep them in
+	// their nodes instead.
+	layerTotals [][]nodeTotals
+	// Whether the nodes of a sparse tree keep totals
+	nodeStats bool
+	// With WithDecay, the factor applied by every Tick, and the count that
+	// an Add adds until the next one
+	decay       float64
+	decayWeight float64
+	// With WithWindow, the colors added during each frame in the window
+	window *frameWindow
 }
 
 type value struct {
 	r, g, b uint8
-	count   uint32
+	count   uint64
 }
 
-func NewOctree(depth int) (*Octree, error) {
-	if depth < 1 || depth > 7 {
+// The color of a value returned by a search.
+func (v value) RGB() (r, g, b uint8) {
+	return v.r, v.g, v.b
+}
+
+// How many times the color was added.
+func (v value) Count() uint64 {
+	return v.count
+}
+
+func NewOctree(depth int, options ...Option) (*Octree, error) {
+	if depth < 1 || depth > maxSparseDepth {
+		return nil, fmt.Errorf("Invalid octree depth: %d", depth)
+	}
+	opts := octreeOptions{}
+	for _, option := range options {
+		option(&opts)
+	}
+	if opts.ordering != MortonOrder && opts.ordering != HilbertOrder {
+		return nil, fmt.Errorf("Invalid octree ordering: %d", opts.ordering)
+	}
+	if opts.maxLeafSize < 0 {
+		return nil, fmt.Errorf("Invalid max leaf size: %d", opts.maxLeafSize)
+	}
+	if opts.decay != 0 && !(opts.decay > 0 && opts.decay < 1) {
+		return nil, fmt.Errorf("Invalid decay factor: %v", opts.decay)
+	}
+	if opts.decay != 0 {
+		// Every Add counts for up to decayLimit, which would saturate 32 bits
+		// within a few frames
+		opts.counts64 = true
+	}
+	if opts.window < 0 {
+		return nil, fmt.Errorf("Invalid window size: %d", opts.window)
+	}
+	if opts.decay != 0 && opts.window != 0 {
+		return nil, fmt.Errorf("Decay and a window can't be used together")
+	}
+	switch opts.storage {
+	case AutoStorage:
+		if depth > maxDenseAutoDepth || opts.maxLeafSize > 0 {
+			return newSparseOctree(depth, opts), nil
+		}
+	case SparseStorage:
+		return newSparseOctree(depth, opts), nil
+	case DenseStorage:
+		if opts.maxLeafSize > 0 {
+			return nil, fmt.Errorf("Adaptive leaves need sparse storage")
+		}
+	default:
+		return nil, fmt.Errorf("Invalid octree storage: %d", opts.storage)
+	}
+	if depth > maxDenseDepth {
 		return nil, fmt.Errorf("Invalid octree depth: %d", depth)
 	}
-	layers := make([][]uint32, depth-1)
+	var layers [][]uint32
+	var layers64 [][]uint64
 	size := 1
-	for i := range layers {
+	for i := 0; i < depth-1; i++ {
 		size *= 8
-		layers[i] = make([]uint32, size)
+		if opts.counts64 {
+			layers64 = append(layers64, make([]uint64, size))
+		} else {
+			layers = append(layers, make([]uint32, size))
+		}
 	}
 	values := make([][]*value, size)
-	return &Octree{
-		layerCounts: layers,
-		values:      values,
-	}, nil
+	var totals [][]nodeTotals
+	if opts.nodeStats {
+		totals = make([][]nodeTotals, depth)
+		for i := range totals {
+			totals[i] = make([]nodeTotals, 1<<(3*i))
+		}
+	}
+	o := &Octree{
+		layerCounts:   layers,
+		layerCounts64: layers64,
+		maxCount:      opts.maxCount(),
+		values:        values,
+		depth:         depth,
+		ordering:      opts.ordering,
+		layerTotals:   totals,
+	}
+	o.setTimeOptions(opts)
+	return o, nil
+}
+
+// The number of levels of a dense tree below the root, so the leaves are at
+// this level.
+func (o *Octree) denseLayers() int {
+	if o.layerCounts64 != nil {
+		return len(o.layerCounts64)
+	}
+	return len(o.layerCounts)
+}
+
+// Saturated reports whether any adds have been dropped because the total
+// count had reached the most that the tree can count, which is 2^32-1 unless
+// it was built WithCounts64. Dropping them keeps every count in the tree
+// consistent, rather than letting some of them wrap. It stays true even once
+// Remove has made room again.
+func (o *Octree) Saturated() bool {
+	return o.saturated
+}
+
+// The number of levels in the tree, including the root.
+func (o *Octree) Depth() int {
+	return o.depth
+}
+
+// Map r,g,b to their index in the ordering used by this tree.
+func (o *Octree) key(r, g, b uint8) uint32 {
+	if o.ordering == HilbertOrder {
+		return hilbertRGB(r, g, b)
+	}
+	return interleaveRGB(r, g, b)
+}
+
+// Map the index of a block 'layers' below the root to the coordinates of the
+// block (the high 'layers' bits of r,g,b).
+func (o *Octree) blockCoords(bindex uint32, layers uint) (r, g, b uint8) {
+	if o.ordering == HilbertOrder {
+		return hilbertDecode(bindex, layers)
+	}
+	return interleavedToRGB(bindex)
+}
+
+// This inverts the effect of blockCoords.
+func (o *Octree) blockIndex(r, g, b uint8, layers uint) uint32 {
+	if o.ordering == HilbertOrder {
+		return hilbertEncode(r, g, b, layers)
+	}
+	return interleaveRGB(r, g, b)
 }
 
 func (o *Octree) Add(r, g, b uint8) {
-	o.count++
-	index := interleaveRGB(r, g, b)
+	if o.decay != 0 {
+		o.addCount(r, g, b, o.decayedAdd())
+		return
+	}
+	added := o.addCount(r, g, b, 1)
+	if o.window != nil && added > 0 {
+		o.window.add(r, g, b)
+	}
+}
+
+// Add count copies of r,g,b at once, or as many as will fit before the tree
+// is saturated. Returns how many were added.
+func (o *Octree) addCount(r, g, b uint8, count uint64) uint64 {
+	if count > o.maxCount-o.count {
+		// No block can count more than the root, so this is the only
+		// count that needs checking
+		o.saturated = true
+		count = o.maxCount - o.count
+		if count == 0 {
+			return 0
+		}
+	}
+	o.count += count
+	index := o.key(r, g, b)
+	if o.root != nil {
+		o.addSparse(r, g, b, index, count)
+		return count
+	}
 	for depth, counts := range o.layerCounts {
 		layerIndex := (index >> (uint(21 - depth*3)))
-		counts[layerIndex]++
+		counts[layerIndex] += uint32(count)
+	}
+	for depth, counts := range o.layerCounts64 {
+		counts[index>>uint(21-depth*3)] += count
+	}
+	vi := index >> uint(24-o.denseLayers()*3)
+	o.values[vi] = addValue(o.values[vi], r, g, b, count)
+	for level, totals := range o.layerTotals {
+		totals[index>>uint(24-level*3)].add(r, g, b, count)
+	}
+	return count
+}
+
+// Remove one count of r,g,b. It returns false (and changes nothing) if r,g,b
+// had not been added. A tree with a window forgets the most recent add of
+// r,g,b, so that it isn't removed again when its frame leaves the window. A
+// decaying tree takes away a single count, not what an Add counts for, which
+// is AddWeight.
+func (o *Octree) Remove(r, g, b uint8) bool {
+	if !o.removeCount(r, g, b, 1) {
+		return false
 	}
-	vi := index >> uint(24-len(o.layerCounts)*3)
+	if o.window != nil {
+		o.window.forget(r, g, b)
+	}
+	return true
+}
+
+// Remove count copies of r,g,b at once. It returns false (and changes
+// nothing) if r,g,b hasn't been counted that many times.
+func (o *Octree) removeCount(r, g, b uint8, count uint64) bool {
+	index := o.key(r, g, b)
+	if o.root != nil {
+		return o.removeSparse(r, g, b, index, count)
+	}
+	vi := index >> uint(24-o.denseLayers()*3)
+	valueSlice, found := removeValue(o.values[vi], r, g, b, count)
+	if !found {
+		return false
+	}
+	o.values[vi] = valueSlice
+	o.count -= count
+	for depth, counts := range o.layerCounts {
+		layerIndex := (index >> (uint(21 - depth*3)))
+		counts[layerIndex] -= uint32(count)
+	}
+	for depth, counts := range o.layerCounts64 {
+		counts[index>>uint(21-depth*3)] -= count
+	}
+	if o.layerTotals != nil {
+		o.removeDenseTotals(r, g, b, index, count, valueSlice)
+	}
+	return true
+}
+
+// Count r,g,b in a leaf's values, returning the updated slice.
+func addValue(valueSlice []*value, r, g, b uint8, count uint64) []*value {
 	// See if we can find this exact value, if not, add it
 	// TODO: We could keep the valueSlice in some sort of sorted order, so
 	// 	 that we could do faster searching. However, it is easier to
 	// 	 just make the octree another depth deeper.
-	valueSlice := o.values[vi]
-	found := false
 	for _, v := range valueSlice {
 		if r == v.r && g == v.g && b == v.b {
-			v.count++
-			found = true
-			break
+			v.count += count
+			return valueSlice
 		}
 	}
-	if !found {
-		v := &value{r: r, g: g, b: b, count: 1}
-		o.values[vi] = append(valueSlice, v)
+	v := &value{r: r, g: g, b: b, count: count}
+	return append(valueSlice, v)
+}
+
+// Uncount count copies of r,g,b from a leaf's values, dropping it once its
+// count reaches 0. Returns the updated slice, and whether r,g,b was found
+// with at least that count.
+func removeValue(valueSlice []*value, r, g, b uint8, count uint64) ([]*value, bool) {
+	for i, v := range valueSlice {
+		if r == v.r && g == v.g && b == v.b {
+			if v.count < count {
+				return valueSlice, false
+			}
+			v.count -= count
+			if v.count == 0 {
+				valueSlice = slices.Delete(valueSlice, i, i+1)
+				if len(valueSlice) == 0 {
+					valueSlice = nil
+				}
+			}
+			return valueSlice, true
+		}
 	}
+	return valueSlice, false
 }
 
 // The distance^2 to a given value
@@ -82,10 +328,14 @@ func dist2ToV(r, g, b uint8, v *value) uint32 {
 // for that block would be. This is a inclusive boundary [min, max] (max and
 // min are inside the block)
 func (o *Octree) findBlockMinMax(bindex uint32) (vMin, vMax value) {
-	blockShift := uint(24 - len(o.layerCounts)*3)
-	index := bindex << blockShift
-	rMin, gMin, bMin := interleavedToRGB(index)
-	stride := uint8(0xFF) >> uint(len(o.layerCounts))
+	return o.blockMinMax(uint(o.denseLayers()), bindex)
+}
+
+// The same as findBlockMinMax, but for a block at any level of the tree.
+func (o *Octree) blockMinMax(level uint, bindex uint32) (vMin, vMax value) {
+	r, g, b := o.blockCoords(bindex, level)
+	rMin, gMin, bMin := r<<(8-level), g<<(8-level), b<<(8-level)
+	stride := uint8(0xFF) >> level
 	vMin = value{r: rMin, g: gMin, b: bMin}
 	vMax = value{r: rMin + stride, g: gMin + stride, b: bMin + stride}
 	return vMin, vMax
@@ -128,8 +378,11 @@ func (o *Octree) findMinDist2ToBoundary(r, g, b uint8, vMin, vMax value) uint32
 }
 
 func (o *Octree) FindClosest(r, g, b uint8) value {
-	index := interleaveRGB(r, g, b)
-	shift := uint(24 - len(o.layerCounts)*3)
+	if o.root != nil {
+		return o.findClosestByDescent(r, g, b)
+	}
+	index := o.key(r, g, b)
+	shift := uint(24 - o.denseLayers()*3)
 	blockIndex := index >> shift
 	valueSlice := o.values[blockIndex]
 	// Pass through looking for an exact match
@@ -203,51 +456,165 @@ func (o *Octree) FindClosest(r, g, b uint8) value {
 	return *closest
 }
 
-// Get a 'neighbor' one less and one greater the value, but cap it at [0,max]
-func getBoundedNeighbor(v, max uint8) (uint8, uint8) {
-	vMin := v
-	if v > 0 {
-		vMin = v - 1
+// Find all of the values inside the inclusive box [min, max], in block order.
+func (o *Octree) FindInBox(rMin, gMin, bMin, rMax, gMax, bMax uint8) []value {
+	// Find the blocks that overlap the box. Only the blocks on the surface
+	// can hold values outside of it, but it is cheaper to just check every
+	// value than to track which blocks are on the surface.
+	var found []value
+	filter := func(values []*value) {
+		for _, v := range values {
+			if v.r >= rMin && v.r <= rMax &&
+				v.g >= gMin && v.g <= gMax &&
+				v.b >= bMin && v.b <= bMax {
+				found = append(found, *v)
+			}
+		}
 	}
-	vMax := v
-	if v < max {
-		vMax = v + 1
+	if o.root != nil {
+		o.eachLeafInBox(o.rootCursor(),
+			value{r: rMin, g: gMin, b: bMin}, value{r: rMax, g: gMax, b: bMax},
+			filter)
+		return found
+	}
+	shift := uint(8 - o.denseLayers())
+	bMinBlock := value{r: rMin >> shift, g: gMin >> shift, b: bMin >> shift}
+	bMaxBlock := value{r: rMax >> shift, g: gMax >> shift, b: bMax >> shift}
+	o.eachBlockInBox(bMinBlock, bMaxBlock, filter)
+	return found
+}
+
+// Call f with the values of every block inside the inclusive box of block
+// coordinates [vMin, vMax], in block order.
+func (o *Octree) eachBlockInBox(vMin, vMax value, f func(values []*value)) {
+	if o.ordering == MortonOrder {
+		// The box is a handful of contiguous runs of blocks
+		for _, run := range mortonBoxRanges(vMin, vMax) {
+			for _, values := range o.values[run.start : run.end+1] {
+				f(values)
+			}
+		}
+		return
+	}
+	layers := uint(o.denseLayers())
+	var blocks []uint32
+	for r := int(vMin.r); r <= int(vMax.r); r++ {
+		for g := int(vMin.g); g <= int(vMax.g); g++ {
+			for b := int(vMin.b); b <= int(vMax.b); b++ {
+				blocks = append(blocks,
+					o.blockIndex(uint8(r), uint8(g), uint8(b), layers))
+			}
+		}
+	}
+	slices.Sort(blocks)
+	for _, block := range blocks {
+		f(o.values[block])
+	}
+}
+
+// Find all of the values within distance of r,g,b (inclusive), in block order.
+func (o *Octree) FindWithin(r, g, b uint8, distance uint8) []value {
+	rMin, rMax := getBoundedRange(r, distance)
+	gMin, gMax := getBoundedRange(g, distance)
+	bMin, bMax := getBoundedRange(b, distance)
+	maxDist2 := uint32(distance) * uint32(distance)
+	found := o.FindInBox(rMin, gMin, bMin, rMax, gMax, bMax)
+	// Filter in place, the box is always a superset of the sphere
+	within := found[:0]
+	for _, v := range found {
+		if dist2ToV(r, g, b, &v) <= maxDist2 {
+			within = append(within, v)
+		}
+	}
+	return within
+}
+
+// Get the range [v-distance, v+distance], but cap it at [0, 0xFF]
+func getBoundedRange(v, distance uint8) (uint8, uint8) {
+	vMin := uint8(0)
+	if v > distance {
+		vMin = v - distance
+	}
+	vMax := uint8(0xFF)
+	if 0xFF-v > distance {
+		vMax = v + distance
 	}
 	return vMin, vMax
 }
 
-// Find all of the blocks that are next to this one.
+// Find all of the blocks that are next to this one, in Morton order.
 // Also include the minimum and maximum boundary of the larger blocks
 func (o *Octree) find26NeighborBlocks(bindex uint32) ([]uint32, value, value) {
-	// Technically, this is only the 'high order' r g b bits shifted by
-	// layer, but it works for finding the correct neighbor indexes
-	r, g, b := interleavedToRGB(bindex)
-	max := uint8(0x01) << uint(len(o.layerCounts)-1)
+	if o.ordering != MortonOrder {
+		return o.find26NeighborBlocksByCoords(bindex)
+	}
+	// Rather than decoding bindex to r,g,b and re-encoding each neighbor,
+	// step each axis directly in the interleaved form. Since every axis owns
+	// its own bits, a neighbor is just the OR of one value from each axis.
+	blockMask := uint32(1)<<uint(o.denseLayers()*3) - 1
+	rs := mortonAxisNeighbors(bindex, mortonMaskR&blockMask)
+	gs := mortonAxisNeighbors(bindex, mortonMaskG&blockMask)
+	bs := mortonAxisNeighbors(bindex, mortonMaskB&blockMask)
+	neighbors := make([]uint32, 0, 26)
+	for _, rr := range rs {
+		for _, gg := range gs {
+			for _, bb := range bs {
+				idx := rr | gg | bb
+				if idx == bindex {
+					continue
+				}
+				neighbors = append(neighbors, idx)
+			}
+		}
+	}
+	// The blocks in memory are stored in Morton order, so visiting the
+	// neighbors in the same order is friendlier to the cache.
+	slices.Sort(neighbors)
+	vMin, _ := o.findBlockMinMax(rs[0] | gs[0] | bs[0])
+	_, vMax := o.findBlockMinMax(rs[len(rs)-1] | gs[len(gs)-1] | bs[len(bs)-1])
+	return neighbors, vMin, vMax
+}
+
+// The same as find26NeighborBlocks, but for orderings where we can't step
+// directly in the index. Decode the block, step each axis, and encode again.
+func (o *Octree) find26NeighborBlocksByCoords(bindex uint32) ([]uint32, value, value) {
+	layers := uint(o.denseLayers())
+	r, g, b := o.blockCoords(bindex, layers)
+	max := uint8(0xFF) >> (8 - layers)
 	rMin, rMax := getBoundedNeighbor(r, max)
 	gMin, gMax := getBoundedNeighbor(g, max)
 	bMin, bMax := getBoundedNeighbor(b, max)
-	vMin := value{r: rMin, g: gMin, b: bMin}
-	vMax := value{r: rMax, g: gMax, b: bMax}
 	neighbors := make([]uint32, 0, 26)
-	// Note: we don't have to worry about overflowing uint8 because
-	// len(layerCounts) is always at least 1
-	// TODO: We walk in r,g,b order, but the blocks in memory are stored in
-	// morton order, for memory purposes, wouldn't it be better to use morton
-	// ordering for the blocks?
-	for rr := rMin; rr <= rMax; rr++ {
-		for gg := gMin; gg <= gMax; gg++ {
-			for bb := bMin; bb <= bMax; bb++ {
-				if rr == r && gg == g && bb == b {
+	for rr := int(rMin); rr <= int(rMax); rr++ {
+		for gg := int(gMin); gg <= int(gMax); gg++ {
+			for bb := int(bMin); bb <= int(bMax); bb++ {
+				idx := o.blockIndex(uint8(rr), uint8(gg), uint8(bb), layers)
+				if idx == bindex {
 					continue
 				}
-				idx := interleaveRGB(rr, gg, bb)
 				neighbors = append(neighbors, idx)
 			}
 		}
 	}
+	slices.Sort(neighbors)
+	vMin, _ := o.findBlockMinMax(o.blockIndex(rMin, gMin, bMin, layers))
+	_, vMax := o.findBlockMinMax(o.blockIndex(rMax, gMax, bMax, layers))
 	return neighbors, vMin, vMax
 }
 
+// Get a 'neighbor' one less and one greater the value, but cap it at [0,max]
+func getBoundedNeighbor(v, max uint8) (uint8, uint8) {
+	vMin := v
+	if v > 0 {
+		vMin = v - 1
+	}
+	vMax := v
+	if v < max {
+		vMax = v + 1
+	}
+	return vMin, vMax
+}
+
 // This is a mapping from 0-256 uint8 into a spread bits format, where each bit
 // in the input gets spread out into the output. (eg 0011 => 000 000 001 001)
 // The table itself comes from
@@ -1,6 +1,8 @@
 package octree
 
 import (
+	"math"
+	"slices"
 	"testing"
 
 	"gopkg.in/check.v1"
@@ -19,7 +21,7 @@ func (*OctTreeSuite) TestNewOctree(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.Assert(oct, check.NotNil)
 	c.Check(len(oct.layerCounts), check.Equals, 0)
-	c.Check(oct.count, check.Equals, uint32(0))
+	c.Check(oct.count, check.Equals, uint64(0))
 	c.Check(len(oct.values), check.Equals, 1)
 }
 
@@ -28,7 +30,7 @@ func (*OctTreeSuite) TestNewOctreeDepth2(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.Assert(oct, check.NotNil)
 	c.Check(oct.layerCounts, check.HasLen, 1)
-	c.Check(oct.count, check.Equals, uint32(0))
+	c.Check(oct.count, check.Equals, uint64(0))
 	c.Check(oct.layerCounts[0], check.HasLen, 8)
 	c.Check(len(oct.values), check.Equals, 8)
 }
@@ -38,7 +40,7 @@ func (*OctTreeSuite) TestNewOctreeDepth3(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.Assert(oct, check.NotNil)
 	c.Check(oct.layerCounts, check.HasLen, 2)
-	c.Check(oct.count, check.Equals, uint32(0))
+	c.Check(oct.count, check.Equals, uint64(0))
 	c.Check(oct.layerCounts[0], check.HasLen, 8)
 	c.Check(oct.layerCounts[1], check.HasLen, 64)
 	c.Check(len(oct.values), check.Equals, 64)
@@ -63,7 +65,7 @@ func checkAdding(c *check.C, r, g, b uint8, l0block, l1block int) {
 	expLayer1 := make([]uint32, 64)
 	expLayer1[l1block]++
 	oct.Add(r, g, b)
-	c.Check(oct.count, check.Equals, uint32(1))
+	c.Check(oct.count, check.Equals, uint64(1))
 	c.Check(oct.layerCounts[0], check.DeepEquals, expLayer0)
 	c.Check(oct.layerCounts[1], check.DeepEquals, expLayer1)
 	for i, blockValues := range oct.values {
@@ -122,7 +124,7 @@ func (*OctTreeSuite) TestRepeatedAdds(c *check.C) {
 	expLayer0[0] += 3
 	expLayer1 := make([]uint32, 64)
 	expLayer1[0] += 3
-	c.Check(oct.count, check.Equals, uint32(3))
+	c.Check(oct.count, check.Equals, uint64(3))
 	c.Check(oct.layerCounts[0], check.DeepEquals, expLayer0)
 	c.Check(oct.layerCounts[1], check.DeepEquals, expLayer1)
 	for i, blockValues := range oct.values {
@@ -149,7 +151,7 @@ func (*OctTreeSuite) TestAddNearby(c *check.C) {
 	expLayer0[0] += 4
 	expLayer1 := make([]uint32, 64)
 	expLayer1[0] += 4
-	c.Check(oct.count, check.Equals, uint32(4))
+	c.Check(oct.count, check.Equals, uint64(4))
 	c.Check(oct.layerCounts[0], check.DeepEquals, expLayer0)
 	c.Check(oct.layerCounts[1], check.DeepEquals, expLayer1)
 	for i, blockValues := range oct.values {
@@ -324,22 +326,128 @@ func (*OctTreeSuite) TestFind26NeighborBlocks3Layer(c *check.C) {
 	c.Assert(len(oct.values), check.Equals, 64)
 	check26NeighborBlocks(c, oct, 0,
 		[]uint32{1, 2, 3, 4, 5, 6, 7})
-	// 7 should be the first block to have all 26 neighbors, the order here
-	// is because we iterate in r,g,b order, but the indices are morton
-	// order.
+	// 7 should be the first block to have all 26 neighbors, they are
+	// returned in morton order, rather than r,g,b order.
 	check26NeighborBlocks(c, oct, 7,
 		[]uint32{
-			// r = 0, g,b = [0, 2]
-			0x00, 0x01, 0x08,
-			0x02, 0x03, 0x0a,
-			0x10, 0x11, 0x18,
-			// r = 1, g,b = [0, 2], but skipping r=g=b=1
-			0x04, 0x05, 0x0c,
-			0x06, 0x0e,
-			0x14, 0x15, 0x1c,
-			// r = 2, g,b = [0, 2]
-			0x20, 0x21, 0x28,
-			0x22, 0x23, 0x2a,
+			0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06,
+			0x08, 0x0a, 0x0c, 0x0e,
+			0x10, 0x11, 0x14, 0x15, 0x18, 0x1c,
+			0x20, 0x21, 0x22, 0x23, 0x28, 0x2a,
 			0x30, 0x31, 0x38,
 		})
+	// r=g=b=2 is one away from the far edge, it still has all 26
+	// neighbors, including the ones with r,g,b = 3
+	neighbors, _, _ := oct.find26NeighborBlocks(0x38)
+	c.Check(neighbors, check.HasLen, 26)
+	c.Check(neighbors[len(neighbors)-1], check.Equals, uint32(0x3f))
+	// and the last block is back to only having 7
+	check26NeighborBlocks(c, oct, 0x3f,
+		[]uint32{0x38, 0x39, 0x3a, 0x3b, 0x3c, 0x3d, 0x3e})
+}
+
+func (*OctTreeSuite) TestFind26NeighborBlocksBoundary(c *check.C) {
+	oct, err := NewOctree(3)
+	c.Assert(err, check.IsNil)
+	// The boundary is the full extent of all the neighbors, in r,g,b
+	// space, not in block space.
+	_, vMin, vMax := oct.find26NeighborBlocks(7)
+	c.Check(vMin, check.DeepEquals, value{r: 0x00, g: 0x00, b: 0x00})
+	c.Check(vMax, check.DeepEquals, value{r: 0xBF, g: 0xBF, b: 0xBF})
+	_, vMin, vMax = oct.find26NeighborBlocks(0x3f)
+	c.Check(vMin, check.DeepEquals, value{r: 0x80, g: 0x80, b: 0x80})
+	c.Check(vMax, check.DeepEquals, value{r: 0xFF, g: 0xFF, b: 0xFF})
+}
+
+func (*OctTreeSuite) TestFindInBox(c *check.C) {
+	oct, err := NewOctree(4)
+	c.Assert(err, check.IsNil)
+	oct.Add(0x10, 0x10, 0x10)
+	oct.Add(0x20, 0x10, 0x10)
+	oct.Add(0x30, 0x10, 0x10)
+	oct.Add(0x20, 0x80, 0x10)
+	oct.Add(0xFF, 0xFF, 0xFF)
+	c.Check(oct.FindInBox(0x18, 0x00, 0x00, 0x30, 0x20, 0x20),
+		check.DeepEquals, []value{
+			{r: 0x20, g: 0x10, b: 0x10, count: 1},
+			{r: 0x30, g: 0x10, b: 0x10, count: 1},
+		})
+	c.Check(oct.FindInBox(0x00, 0x00, 0x00, 0xFF, 0xFF, 0xFF), check.HasLen, 5)
+	c.Check(oct.FindInBox(0xF0, 0xF0, 0xF0, 0xFE, 0xFF, 0xFF), check.HasLen, 0)
+	c.Check(oct.FindInBox(0x30, 0x00, 0x00, 0x10, 0xFF, 0xFF), check.HasLen, 0)
+}
+
+func (*OctTreeSuite) TestFindWithin(c *check.C) {
+	oct, err := NewOctree(4)
+	c.Assert(err, check.IsNil)
+	oct.Add(0x10, 0x10, 0x10)
+	oct.Add(0x18, 0x18, 0x10)
+	oct.Add(0x20, 0x10, 0x10)
+	oct.Add(0xFF, 0xFF, 0xFF)
+	// 0x18,0x18 is inside the box, but not inside the sphere
+	c.Check(oct.FindWithin(0x10, 0x10, 0x10, 0x0a), check.DeepEquals,
+		[]value{{r: 0x10, g: 0x10, b: 0x10, count: 1}})
+	c.Check(oct.FindWithin(0x10, 0x10, 0x10, 0x10), check.HasLen, 3)
+	c.Check(oct.FindWithin(0xFF, 0xFF, 0xFF, 0x10), check.DeepEquals,
+		[]value{{r: 0xFF, g: 0xFF, b: 0xFF, count: 1}})
+}
+
+func (*OctTreeSuite) TestValueAccessors(c *check.C) {
+	oct, err := NewOctree(3)
+	c.Assert(err, check.IsNil)
+	oct.Add(1, 2, 3)
+	oct.Add(1, 2, 3)
+	v := oct.FindClosest(0, 0, 0)
+	r, g, b := v.RGB()
+	c.Check([]uint8{r, g, b}, check.DeepEquals, []uint8{1, 2, 3})
+	c.Check(v.Count(), check.Equals, uint64(2))
+}
+
+func (*OctTreeSuite) TestSaturated(c *check.C) {
+	for _, storage := range []Storage{DenseStorage, SparseStorage} {
+		oct, err := NewOctree(3, WithStorage(storage))
+		c.Assert(err, check.IsNil)
+		oct.addCount(0x10, 0x20, 0x30, math.MaxUint32-1)
+		c.Check(oct.Saturated(), check.Equals, false)
+		oct.Add(0xF0, 0xF0, 0xF0)
+		c.Check(oct.Saturated(), check.Equals, false)
+		// Nothing fits any more, so nothing is counted, rather than the
+		// root wrapping to 0
+		oct.Add(0xF0, 0xF0, 0xF0)
+		oct.addCount(0x10, 0x20, 0x30, 5)
+		c.Check(oct.Saturated(), check.Equals, true)
+		c.Check(oct.count, check.Equals, uint64(math.MaxUint32))
+		c.Check(oct.FindClosest(0xFF, 0xFF, 0xFF).Count(), check.Equals, uint64(1))
+		c.Check(slices.Collect(oct.Nodes(1)), check.DeepEquals, []Node{
+			{Level: 1, Index: 0, Count: math.MaxUint32 - 1, Max: [3]uint8{0x7F, 0x7F, 0x7F}},
+			{Level: 1, Index: 7, Count: 1,
+				Min: [3]uint8{0x80, 0x80, 0x80}, Max: [3]uint8{0xFF, 0xFF, 0xFF}},
+		})
+		// Whatever was dropped stays lost, even once there is room again
+		c.Check(oct.Remove(0xF0, 0xF0, 0xF0), check.Equals, true)
+		c.Check(oct.Saturated(), check.Equals, true)
+		oct.Add(0x10, 0x20, 0x30)
+		c.Check(oct.count, check.Equals, uint64(math.MaxUint32))
+	}
+}
+
+func (*OctTreeSuite) TestCounts64(c *check.C) {
+	for _, storage := range []Storage{DenseStorage, SparseStorage} {
+		oct, err := NewOctree(3, WithStorage(storage), WithCounts64())
+		c.Assert(err, check.IsNil)
+		c.Check(oct.layerCounts, check.IsNil)
+		oct.addCount(0x10, 0x20, 0x30, math.MaxUint32)
+		oct.addCount(0x10, 0x20, 0x30, 10)
+		oct.Add(0xF0, 0xF0, 0xF0)
+		c.Check(oct.Saturated(), check.Equals, false)
+		c.Check(oct.count, check.Equals, uint64(math.MaxUint32)+11)
+		c.Check(oct.FindClosest(0, 0, 0).Count(), check.Equals, uint64(math.MaxUint32)+10)
+		var counts []uint64
+		for n := range oct.Nodes(2) {
+			counts = append(counts, n.Count)
+		}
+		c.Check(counts, check.DeepEquals, []uint64{math.MaxUint32 + 10, 1})
+		c.Check(oct.Remove(0x10, 0x20, 0x30), check.Equals, true)
+		c.Check(oct.cursorCount(oct.rootCursor()), check.Equals, uint64(math.MaxUint32)+10)
+	}
 }
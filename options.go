@@ -0,0 +1,170 @@
+package octree
+
+import (
+	"fmt"
+	"math"
+)
+
+// Ordering selects how blocks are laid out in memory.
+type Ordering int
+
+const (
+	// MortonOrder lays blocks out along a Z-order curve, by interleaving the
+	// bits of r, g and b. This is the default, and the cheapest to compute.
+	MortonOrder Ordering = iota
+	// HilbertOrder lays blocks out along a 3D Hilbert curve. It is more
+	// expensive to compute, but blocks that are next to each other in the
+	// curve are always next to each other in color space.
+	HilbertOrder
+)
+
+func (o Ordering) String() string {
+	switch o {
+	case MortonOrder:
+		return "morton"
+	case HilbertOrder:
+		return "hilbert"
+	}
+	return fmt.Sprintf("Ordering(%d)", int(o))
+}
+
+// An Option changes how NewOctree builds a tree.
+type Option func(*octreeOptions)
+
+type octreeOptions struct {
+	ordering    Ordering
+	storage     Storage
+	maxLeafSize int
+	nodeStats   bool
+	counts64    bool
+	decay       float64
+	window      int
+}
+
+// The most that the total count of a tree can reach.
+func (opts octreeOptions) maxCount() uint64 {
+	if opts.counts64 {
+		return math.MaxUint64
+	}
+	return math.MaxUint32
+}
+
+// WithOrdering sets the order that blocks are stored in.
+func WithOrdering(ordering Ordering) Option {
+	return func(opts *octreeOptions) {
+		opts.ordering = ordering
+	}
+}
+
+// Storage selects how the blocks of a tree are held in memory.
+type Storage int
+
+const (
+	// AutoStorage picks DenseStorage for shallow trees, and SparseStorage
+	// for deep ones.
+	AutoStorage Storage = iota
+	// DenseStorage allocates every block up front, in flat slices. Adding
+	// and searching are as fast as they can be, but memory grows by 8x with
+	// every layer, regardless of how many colors are added.
+	DenseStorage
+	// SparseStorage only allocates the blocks that hold colors, as a tree of
+	// nodes. It costs more per color, and every lookup has to walk down from
+	// the root, but it doesn't grow with depth.
+	SparseStorage
+)
+
+func (s Storage) String() string {
+	switch s {
+	case AutoStorage:
+		return "auto"
+	case DenseStorage:
+		return "dense"
+	case SparseStorage:
+		return "sparse"
+	}
+	return fmt.Sprintf("Storage(%d)", int(s))
+}
+
+const (
+	// AutoStorage uses dense storage up to this depth.
+	maxDenseAutoDepth = 6
+	// Beyond this, dense storage takes too much memory.
+	maxDenseDepth = 7
+	// At this depth every leaf is a single color.
+	maxSparseDepth = 9
+)
+
+// WithStorage sets how the blocks of the tree are held in memory.
+func WithStorage(storage Storage) Option {
+	return func(opts *octreeOptions) {
+		opts.storage = storage
+	}
+}
+
+// WithAdaptiveLeaves makes leaves split into 8 children once they hold more
+// than maxLeafSize distinct colors, and merge back once the children hold no
+// more than half that, so the cost of searching a leaf stays bounded no
+// matter how the colors are distributed. The depth passed to NewOctree is the
+// deepest that a leaf may go. Adaptive leaves need sparse storage.
+func WithAdaptiveLeaves(maxLeafSize int) Option {
+	return func(opts *octreeOptions) {
+		opts.maxLeafSize = maxLeafSize
+	}
+}
+
+// WithNodeStats makes every block keep running totals of the colors inside
+// it, so that NodeStats can answer without walking the block, and searches
+// can skip blocks by where their colors actually are rather than by the
+// bounds of the block. This costs memory for every block, and Remove has to
+// refit the bounds of the blocks above a color that is no longer held.
+func WithNodeStats() Option {
+	return func(opts *octreeOptions) {
+		opts.nodeStats = true
+	}
+}
+
+// WithCounts64 makes the tree count with 64 bits rather than 32, so it can
+// count more than 2^32-1 colors before it saturates. This doubles the memory
+// taken by the counts of a dense tree.
+func WithCounts64() Option {
+	return func(opts *octreeOptions) {
+		opts.counts64 = true
+	}
+}
+
+// WithDecay makes every Tick scale down everything counted so far by factor,
+// which must be above 0 and below 1, so that recent colors dominate. See
+// Tick for how the counts are kept. As each Add counts for between 256 and
+// 65536, decay implies WithCounts64. Decay can't be used with a window.
+func WithDecay(factor float64) Option {
+	return func(opts *octreeOptions) {
+		opts.decay = factor
+	}
+}
+
+// WithWindow makes the tree only count the colors added during the last
+// frames ticks, including the current one. Every Tick removes the colors of
+// the frame that falls out of the window. A window can't be used with decay.
+func WithWindow(frames int) Option {
+	return func(opts *octreeOptions) {
+		opts.window = frames
+	}
+}
+
+// The options that o was built with, apart from its storage.
+func (o *Octree) options() []Option {
+	options := []Option{WithOrdering(o.ordering), WithAdaptiveLeaves(o.maxLeafSize)}
+	if o.layerTotals != nil || o.nodeStats {
+		options = append(options, WithNodeStats())
+	}
+	if o.maxCount > math.MaxUint32 {
+		options = append(options, WithCounts64())
+	}
+	if o.decay != 0 {
+		options = append(options, WithDecay(o.decay))
+	}
+	if o.window != nil {
+		options = append(options, WithWindow(o.window.size))
+	}
+	return options
+}
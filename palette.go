@@ -0,0 +1,467 @@
+package octree
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// PaletteFormat selects the file format read by ReadPalette and written by
+// WritePalette.
+type PaletteFormat int
+
+const (
+	// HexPalette is a plain list of "#rrggbb" colors, separated by
+	// whitespace or commas. The '#' is optional.
+	HexPalette PaletteFormat = iota
+	// GPLPalette is a GIMP palette (.gpl).
+	GPLPalette
+	// ACTPalette is an Adobe Color Table (.act), up to 256 colors.
+	ACTPalette
+	// ASEPalette is an Adobe Swatch Exchange file (.ase).
+	ASEPalette
+	// PaintNETPalette is a Paint.NET palette (.txt), of "aarrggbb" colors.
+	PaintNETPalette
+)
+
+var paletteFormatNames = map[string]PaletteFormat{
+	"hex":      HexPalette,
+	"gpl":      GPLPalette,
+	"act":      ACTPalette,
+	"ase":      ASEPalette,
+	"txt":      PaintNETPalette,
+	"paintnet": PaintNETPalette,
+}
+
+func (f PaletteFormat) String() string {
+	switch f {
+	case HexPalette:
+		return "hex"
+	case GPLPalette:
+		return "gpl"
+	case ACTPalette:
+		return "act"
+	case ASEPalette:
+		return "ase"
+	case PaintNETPalette:
+		return "paintnet"
+	}
+	return fmt.Sprintf("PaletteFormat(%d)", int(f))
+}
+
+// ParsePaletteFormat finds the format for a name, as returned by String, or
+// a file extension such as ".gpl". Paint.NET palettes use ".txt".
+func ParsePaletteFormat(name string) (PaletteFormat, error) {
+	format, ok := paletteFormatNames[strings.ToLower(strings.TrimPrefix(name, "."))]
+	if !ok {
+		return 0, fmt.Errorf("Unknown palette format: %q", name)
+	}
+	return format, nil
+}
+
+// A PaletteColor is one entry of a palette. Name is only kept by the formats
+// that have names (GPL and ASE), and may be empty. Count is how many times
+// the color was seen, when it comes from a tree, and is not kept by any of
+// the palette file formats.
+type PaletteColor struct {
+	R, G, B uint8
+	Name    string
+	Count   uint64
+}
+
+type paletteColorJSON struct {
+	Color string `json:"color"`
+	Name  string `json:"name,omitempty"`
+	Count uint64 `json:"count,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler, with the color as a "#rrggbb" hex
+// string like value.
+func (p PaletteColor) MarshalJSON() ([]byte, error) {
+	return json.Marshal(paletteColorJSON{
+		Color: hexColor(p.R, p.G, p.B),
+		Name:  p.Name,
+		Count: p.Count,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler, the inverse of MarshalJSON.
+func (p *PaletteColor) UnmarshalJSON(data []byte) error {
+	var pj paletteColorJSON
+	if err := json.Unmarshal(data, &pj); err != nil {
+		return err
+	}
+	r, g, b, err := parseHexColor(pj.Color)
+	if err != nil {
+		return err
+	}
+	*p = PaletteColor{R: r, G: g, B: b, Name: pj.Name, Count: pj.Count}
+	return nil
+}
+
+// The distinct colors of the tree, with their counts, in Morton order.
+func (o *Octree) Palette() []PaletteColor {
+	values := o.sortedValues()
+	palette := make([]PaletteColor, len(values))
+	for i, v := range values {
+		palette[i] = PaletteColor{R: v.r, G: v.g, B: v.b, Count: v.count}
+	}
+	return palette
+}
+
+// LoadPalette reads a palette and adds each of its colors to a new tree, so
+// that FindClosest finds the nearest palette color.
+func LoadPalette(r io.Reader, format PaletteFormat, depth int, options ...Option) (*Octree, error) {
+	palette, err := ReadPalette(r, format)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := NewOctree(depth, options...)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range palette {
+		tree.Add(p.R, p.G, p.B)
+	}
+	return tree, nil
+}
+
+// ReadPalette reads the colors of a palette file, in the order they are
+// listed.
+func ReadPalette(r io.Reader, format PaletteFormat) ([]PaletteColor, error) {
+	switch format {
+	case HexPalette:
+		return readHexPalette(r)
+	case GPLPalette:
+		return readGPLPalette(r)
+	case ACTPalette:
+		return readACTPalette(r)
+	case ASEPalette:
+		return readASEPalette(r)
+	case PaintNETPalette:
+		return readPaintNETPalette(r)
+	}
+	return nil, fmt.Errorf("Invalid palette format: %d", format)
+}
+
+// WritePalette writes palette as a palette file.
+func WritePalette(w io.Writer, format PaletteFormat, palette []PaletteColor) error {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case HexPalette:
+		writeHexPalette(&buf, palette)
+	case GPLPalette:
+		writeGPLPalette(&buf, palette)
+	case ACTPalette:
+		err = writeACTPalette(&buf, palette)
+	case ASEPalette:
+		writeASEPalette(&buf, palette)
+	case PaintNETPalette:
+		writePaintNETPalette(&buf, palette)
+	default:
+		err = fmt.Errorf("Invalid palette format: %d", format)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+func readHexPalette(r io.Reader) ([]PaletteColor, error) {
+	var palette []PaletteColor
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.FieldsFunc(scanner.Text(), func(c rune) bool {
+			return c == ',' || c == ' ' || c == '\t' || c == '\r'
+		})
+		for _, field := range fields {
+			red, green, blue, err := parseHexColor(field)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid hex palette: line %d: %v", line, err)
+			}
+			palette = append(palette, PaletteColor{R: red, G: green, B: blue})
+		}
+	}
+	return palette, scanner.Err()
+}
+
+func writeHexPalette(w *bytes.Buffer, palette []PaletteColor) {
+	for _, p := range palette {
+		fmt.Fprintln(w, hexColor(p.R, p.G, p.B))
+	}
+}
+
+// A GPL file starts with a "GIMP Palette" line, and then has one color per
+// line as decimal "r g b", optionally followed by a name. Lines starting with
+// '#' are comments, and there may be "Name:" and "Columns:" headers.
+func readGPLPalette(r io.Reader) ([]PaletteColor, error) {
+	var palette []PaletteColor
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != "GIMP Palette" {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("Invalid GPL palette: missing \"GIMP Palette\" header")
+	}
+	for line := 2; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' ||
+			strings.HasPrefix(text, "Name:") || strings.HasPrefix(text, "Columns:") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("Invalid GPL palette: line %d: expected r g b, got %q",
+				line, text)
+		}
+		var rgb [3]uint8
+		for i := range rgb {
+			c, err := strconv.ParseUint(fields[i], 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid GPL palette: line %d: invalid channel %q",
+					line, fields[i])
+			}
+			rgb[i] = uint8(c)
+		}
+		palette = append(palette, PaletteColor{
+			R: rgb[0], G: rgb[1], B: rgb[2],
+			Name: strings.Join(fields[3:], " "),
+		})
+	}
+	return palette, scanner.Err()
+}
+
+func writeGPLPalette(w *bytes.Buffer, palette []PaletteColor) {
+	fmt.Fprintln(w, "GIMP Palette")
+	fmt.Fprintln(w, "#")
+	for _, p := range palette {
+		name := p.Name
+		if name == "" {
+			name = hexColor(p.R, p.G, p.B)
+		}
+		fmt.Fprintf(w, "%3d %3d %3d\t%s\n", p.R, p.G, p.B, name)
+	}
+}
+
+// An ACT file is always 256 r,g,b triples, optionally followed by the number
+// of colors that are used and the index of the transparent color, as 16 bit
+// big-endian integers.
+const (
+	actColors      = 256
+	actSize        = 3 * actColors
+	actTrailerSize = 4
+)
+
+func readACTPalette(r io.Reader) ([]PaletteColor, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	used := actColors
+	switch len(data) {
+	case actSize:
+	case actSize + actTrailerSize:
+		used = int(binary.BigEndian.Uint16(data[actSize:]))
+		if used > actColors {
+			return nil, fmt.Errorf("Invalid ACT palette: %d colors is more than %d",
+				used, actColors)
+		}
+	default:
+		return nil, fmt.Errorf("Invalid ACT palette: expected %d or %d bytes, got %d",
+			actSize, actSize+actTrailerSize, len(data))
+	}
+	palette := make([]PaletteColor, used)
+	for i := range palette {
+		palette[i] = PaletteColor{R: data[3*i], G: data[3*i+1], B: data[3*i+2]}
+	}
+	return palette, nil
+}
+
+func writeACTPalette(w *bytes.Buffer, palette []PaletteColor) error {
+	if len(palette) > actColors {
+		return fmt.Errorf("Too many colors for an ACT palette: %d is more than %d",
+			len(palette), actColors)
+	}
+	data := make([]byte, actSize, actSize+actTrailerSize)
+	for i, p := range palette {
+		data[3*i], data[3*i+1], data[3*i+2] = p.R, p.G, p.B
+	}
+	data = binary.BigEndian.AppendUint16(data, uint16(len(palette)))
+	// No transparent color
+	data = binary.BigEndian.AppendUint16(data, 0xFFFF)
+	w.Write(data)
+	return nil
+}
+
+// An ASE file is the magic "ASEF", a version (1.0), and a count of blocks,
+// all big-endian. Each block is a 16 bit type and 32 bit length, and color
+// blocks hold a UTF-16 name, a color model, its channels as float32s, and a
+// swatch type. Groups are flattened, colors are returned in file order.
+const (
+	aseMagic        = "ASEF"
+	aseHeaderSize   = 12
+	aseBlockColor   = 0x0001
+	aseBlockHeader  = 6
+	aseSwatchNormal = 2
+)
+
+func readASEPalette(r io.Reader) ([]PaletteColor, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aseHeaderSize || string(data[:4]) != aseMagic {
+		return nil, fmt.Errorf("Invalid ASE palette: missing %q header", aseMagic)
+	}
+	if major := binary.BigEndian.Uint16(data[4:]); major != 1 {
+		return nil, fmt.Errorf("Unsupported ASE palette version: %d", major)
+	}
+	blocks := binary.BigEndian.Uint32(data[8:])
+	data = data[aseHeaderSize:]
+	var palette []PaletteColor
+	for i := uint32(0); i < blocks; i++ {
+		if len(data) < aseBlockHeader {
+			return nil, fmt.Errorf("Truncated ASE palette: block %d of %d is missing", i, blocks)
+		}
+		blockType := binary.BigEndian.Uint16(data)
+		length := binary.BigEndian.Uint32(data[2:])
+		data = data[aseBlockHeader:]
+		if uint64(length) > uint64(len(data)) {
+			return nil, fmt.Errorf("Truncated ASE palette: block %d needs %d bytes, only have %d",
+				i, length, len(data))
+		}
+		block := data[:length]
+		data = data[length:]
+		if blockType != aseBlockColor {
+			// Groups only name the colors inside them
+			continue
+		}
+		p, err := parseASEColor(block)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ASE palette: block %d: %v", i, err)
+		}
+		palette = append(palette, p)
+	}
+	return palette, nil
+}
+
+func parseASEColor(block []byte) (PaletteColor, error) {
+	var p PaletteColor
+	if len(block) < 2 {
+		return p, fmt.Errorf("missing name")
+	}
+	// The length is in UTF-16 code units, including a trailing 0
+	nameLen := int(binary.BigEndian.Uint16(block))
+	block = block[2:]
+	if len(block) < 2*nameLen+4 {
+		return p, fmt.Errorf("name is truncated")
+	}
+	name := make([]uint16, nameLen)
+	for i := range name {
+		name[i] = binary.BigEndian.Uint16(block[2*i:])
+	}
+	if nameLen > 0 && name[nameLen-1] == 0 {
+		name = name[:nameLen-1]
+	}
+	p.Name = string(utf16.Decode(name))
+	block = block[2*nameLen:]
+	model := string(block[:4])
+	block = block[4:]
+	if model == "LAB " {
+		// Converting needs a white point that the file doesn't give
+		return p, fmt.Errorf("unsupported color space LAB")
+	}
+	channels := map[string]int{"RGB ": 3, "CMYK": 4, "Gray": 1}[model]
+	if channels == 0 {
+		return p, fmt.Errorf("unknown color model %q", model)
+	}
+	if len(block) < 4*channels {
+		return p, fmt.Errorf("%q color is truncated", model)
+	}
+	var f [4]float64
+	for i := 0; i < channels; i++ {
+		f[i] = float64(math.Float32frombits(binary.BigEndian.Uint32(block[4*i:])))
+	}
+	switch model {
+	case "RGB ":
+		p.R, p.G, p.B = unitToUint8(f[0]), unitToUint8(f[1]), unitToUint8(f[2])
+	case "Gray":
+		p.R = unitToUint8(f[0])
+		p.G, p.B = p.R, p.R
+	case "CMYK":
+		// Without a color profile, the best we can do is the naive conversion
+		k := 1 - f[3]
+		p.R = unitToUint8((1 - f[0]) * k)
+		p.G = unitToUint8((1 - f[1]) * k)
+		p.B = unitToUint8((1 - f[2]) * k)
+	}
+	return p, nil
+}
+
+// Scale a channel in [0, 1] to [0, 255].
+func unitToUint8(f float64) uint8 {
+	return uint8(math.Round(min(max(f, 0), 1) * 255))
+}
+
+func writeASEPalette(w *bytes.Buffer, palette []PaletteColor) {
+	w.WriteString(aseMagic)
+	binary.Write(w, binary.BigEndian, [2]uint16{1, 0})
+	binary.Write(w, binary.BigEndian, uint32(len(palette)))
+	for _, p := range palette {
+		name := p.Name
+		if name == "" {
+			name = hexColor(p.R, p.G, p.B)
+		}
+		units := append(utf16.Encode([]rune(name)), 0)
+		binary.Write(w, binary.BigEndian, uint16(aseBlockColor))
+		binary.Write(w, binary.BigEndian, uint32(2+2*len(units)+4+3*4+2))
+		binary.Write(w, binary.BigEndian, uint16(len(units)))
+		binary.Write(w, binary.BigEndian, units)
+		w.WriteString("RGB ")
+		binary.Write(w, binary.BigEndian, [3]float32{
+			float32(p.R) / 255, float32(p.G) / 255, float32(p.B) / 255,
+		})
+		binary.Write(w, binary.BigEndian, uint16(aseSwatchNormal))
+	}
+}
+
+// A Paint.NET palette has one "aarrggbb" color per line, and lines starting
+// with ';' are comments. Alpha is ignored.
+func readPaintNETPalette(r io.Reader) ([]PaletteColor, error) {
+	var palette []PaletteColor
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == ';' {
+			continue
+		}
+		if len(text) != 8 {
+			return nil, fmt.Errorf("Invalid Paint.NET palette: line %d: expected aarrggbb, got %q",
+				line, text)
+		}
+		red, green, blue, err := parseHexColor(text[2:])
+		if _, alphaErr := strconv.ParseUint(text[:2], 16, 8); err != nil || alphaErr != nil {
+			return nil, fmt.Errorf("Invalid Paint.NET palette: line %d: expected aarrggbb, got %q",
+				line, text)
+		}
+		palette = append(palette, PaletteColor{R: red, G: green, B: blue})
+	}
+	return palette, scanner.Err()
+}
+
+func writePaintNETPalette(w *bytes.Buffer, palette []PaletteColor) {
+	fmt.Fprintln(w, "; paint.net Palette File")
+	fmt.Fprintf(w, "; Colors: %d\n", len(palette))
+	for _, p := range palette {
+		fmt.Fprintf(w, "FF%02X%02X%02X\n", p.R, p.G, p.B)
+	}
+}
@@ -0,0 +1,220 @@
+package octree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"strings"
+
+	"gopkg.in/check.v1"
+)
+
+type PaletteSuite struct{}
+
+var _ = check.Suite(&PaletteSuite{})
+
+var testPalette = []PaletteColor{
+	{R: 0xFF, G: 0x00, B: 0x00, Name: "Red"},
+	{R: 0x01, G: 0x02, B: 0x03, Name: "Almost black"},
+	{R: 0x80, G: 0x80, B: 0x80, Name: "Grey"},
+}
+
+func (*PaletteSuite) TestParsePaletteFormat(c *check.C) {
+	for name, expected := range map[string]PaletteFormat{
+		"hex":      HexPalette,
+		".GPL":     GPLPalette,
+		"act":      ACTPalette,
+		".ase":     ASEPalette,
+		".txt":     PaintNETPalette,
+		"paintnet": PaintNETPalette,
+	} {
+		format, err := ParsePaletteFormat(name)
+		c.Check(err, check.IsNil)
+		c.Check(format, check.Equals, expected, check.Commentf(name))
+	}
+	_, err := ParsePaletteFormat(".png")
+	c.Check(err, check.ErrorMatches, `Unknown palette format: ".png"`)
+	for _, format := range []PaletteFormat{HexPalette, GPLPalette, ACTPalette, ASEPalette, PaintNETPalette} {
+		parsed, err := ParsePaletteFormat(format.String())
+		c.Check(err, check.IsNil)
+		c.Check(parsed, check.Equals, format)
+	}
+}
+
+func (*PaletteSuite) TestRoundTrip(c *check.C) {
+	for _, format := range []PaletteFormat{HexPalette, GPLPalette, ACTPalette, ASEPalette, PaintNETPalette} {
+		var buf bytes.Buffer
+		c.Assert(WritePalette(&buf, format, testPalette), check.IsNil)
+		palette, err := ReadPalette(&buf, format)
+		c.Assert(err, check.IsNil, check.Commentf("%v", format))
+		c.Assert(palette, check.HasLen, len(testPalette))
+		for i, p := range palette {
+			expected := testPalette[i]
+			if format != GPLPalette && format != ASEPalette {
+				// Only these keep names
+				expected.Name = ""
+			}
+			c.Check(p, check.Equals, expected, check.Commentf("%v", format))
+		}
+	}
+}
+
+func (*PaletteSuite) TestReadHex(c *check.C) {
+	palette, err := ReadPalette(strings.NewReader("#ff0000, 00ff00\r\n\n  #0000FF\n"), HexPalette)
+	c.Assert(err, check.IsNil)
+	c.Check(palette, check.DeepEquals, []PaletteColor{
+		{R: 0xFF}, {G: 0xFF}, {B: 0xFF},
+	})
+	_, err = ReadPalette(strings.NewReader("#ff0000\n#ff00\n"), HexPalette)
+	c.Check(err, check.ErrorMatches, `Invalid hex palette: line 2: Invalid hex color: "#ff00"`)
+}
+
+func (*PaletteSuite) TestReadGPL(c *check.C) {
+	gpl := `GIMP Palette
+Name: Test
+Columns: 4
+# a comment
+255   0   0	Bright red
+  0 128   0
+`
+	palette, err := ReadPalette(strings.NewReader(gpl), GPLPalette)
+	c.Assert(err, check.IsNil)
+	c.Check(palette, check.DeepEquals, []PaletteColor{
+		{R: 255, Name: "Bright red"},
+		{G: 128},
+	})
+	_, err = ReadPalette(strings.NewReader("0 0 0\n"), GPLPalette)
+	c.Check(err, check.ErrorMatches, `Invalid GPL palette: missing "GIMP Palette" header`)
+	_, err = ReadPalette(strings.NewReader("GIMP Palette\n0 0\n"), GPLPalette)
+	c.Check(err, check.ErrorMatches, `Invalid GPL palette: line 2: expected r g b, got "0 0"`)
+	_, err = ReadPalette(strings.NewReader("GIMP Palette\n0 256 0\n"), GPLPalette)
+	c.Check(err, check.ErrorMatches, `Invalid GPL palette: line 2: invalid channel "256"`)
+}
+
+func (*PaletteSuite) TestACT(c *check.C) {
+	var buf bytes.Buffer
+	c.Assert(WritePalette(&buf, ACTPalette, testPalette), check.IsNil)
+	data := buf.Bytes()
+	c.Assert(data, check.HasLen, 772)
+	c.Check(data[:9], check.DeepEquals, []byte{0xFF, 0, 0, 1, 2, 3, 0x80, 0x80, 0x80})
+	c.Check(data[768:], check.DeepEquals, []byte{0, 3, 0xFF, 0xFF})
+	// Without the trailer, all 256 colors are used
+	palette, err := ReadPalette(bytes.NewReader(data[:768]), ACTPalette)
+	c.Assert(err, check.IsNil)
+	c.Check(palette, check.HasLen, 256)
+	_, err = ReadPalette(bytes.NewReader(data[:700]), ACTPalette)
+	c.Check(err, check.ErrorMatches, `Invalid ACT palette: expected 768 or 772 bytes, got 700`)
+	binary.BigEndian.PutUint16(data[768:], 257)
+	_, err = ReadPalette(bytes.NewReader(data), ACTPalette)
+	c.Check(err, check.ErrorMatches, `Invalid ACT palette: 257 colors is more than 256`)
+	err = WritePalette(&buf, ACTPalette, make([]PaletteColor, 257))
+	c.Check(err, check.ErrorMatches, `Too many colors for an ACT palette: 257 is more than 256`)
+}
+
+// Build an ASE color block by hand, rather than trusting our writer.
+func aseColorBlock(name, model string, channels ...float32) []byte {
+	var body bytes.Buffer
+	units := []uint16{}
+	for _, r := range name {
+		units = append(units, uint16(r))
+	}
+	units = append(units, 0)
+	binary.Write(&body, binary.BigEndian, uint16(len(units)))
+	binary.Write(&body, binary.BigEndian, units)
+	body.WriteString(model)
+	for _, ch := range channels {
+		binary.Write(&body, binary.BigEndian, math.Float32bits(ch))
+	}
+	binary.Write(&body, binary.BigEndian, uint16(0))
+	var block bytes.Buffer
+	binary.Write(&block, binary.BigEndian, uint16(1))
+	binary.Write(&block, binary.BigEndian, uint32(body.Len()))
+	block.Write(body.Bytes())
+	return block.Bytes()
+}
+
+func aseFile(blocks ...[]byte) []byte {
+	data := []byte("ASEF\x00\x01\x00\x00")
+	data = binary.BigEndian.AppendUint32(data, uint32(len(blocks)))
+	for _, block := range blocks {
+		data = append(data, block...)
+	}
+	return data
+}
+
+func (*PaletteSuite) TestReadASE(c *check.C) {
+	groupStart := []byte{0xC0, 0x01, 0, 0, 0, 4, 0, 1, 0, 0}
+	groupEnd := []byte{0xC0, 0x02, 0, 0, 0, 0}
+	data := aseFile(
+		groupStart,
+		aseColorBlock("Orange", "RGB ", 1, 0.5, 0),
+		aseColorBlock("Mid", "Gray", 0.5),
+		groupEnd,
+		aseColorBlock("Cyan", "CMYK", 1, 0, 0, 0),
+	)
+	palette, err := ReadPalette(bytes.NewReader(data), ASEPalette)
+	c.Assert(err, check.IsNil)
+	c.Check(palette, check.DeepEquals, []PaletteColor{
+		{R: 255, G: 128, B: 0, Name: "Orange"},
+		{R: 128, G: 128, B: 128, Name: "Mid"},
+		{R: 0, G: 255, B: 255, Name: "Cyan"},
+	})
+	_, err = ReadPalette(bytes.NewReader(aseFile(aseColorBlock("L", "LAB ", 50, 0, 0))), ASEPalette)
+	c.Check(err, check.ErrorMatches, `Invalid ASE palette: block 0: unsupported color space LAB`)
+	_, err = ReadPalette(bytes.NewReader(aseFile(aseColorBlock("X", "XYZ ", 0))), ASEPalette)
+	c.Check(err, check.ErrorMatches, `Invalid ASE palette: block 0: unknown color model "XYZ "`)
+	_, err = ReadPalette(bytes.NewReader(data[:len(data)-3]), ASEPalette)
+	c.Check(err, check.ErrorMatches, `Truncated ASE palette: block 4 needs .*`)
+	_, err = ReadPalette(strings.NewReader("GIMP Palette"), ASEPalette)
+	c.Check(err, check.ErrorMatches, `Invalid ASE palette: missing "ASEF" header`)
+	data[5] = 2
+	_, err = ReadPalette(bytes.NewReader(data), ASEPalette)
+	c.Check(err, check.ErrorMatches, `Unsupported ASE palette version: 2`)
+}
+
+func (*PaletteSuite) TestReadPaintNET(c *check.C) {
+	txt := "; paint.net Palette File\r\n;Colors: 2\r\nFFFF0000\r\n80010203\r\n"
+	palette, err := ReadPalette(strings.NewReader(txt), PaintNETPalette)
+	c.Assert(err, check.IsNil)
+	c.Check(palette, check.DeepEquals, []PaletteColor{{R: 0xFF}, {R: 1, G: 2, B: 3}})
+	_, err = ReadPalette(strings.NewReader("FF0000\n"), PaintNETPalette)
+	c.Check(err, check.ErrorMatches, `Invalid Paint.NET palette: line 1: expected aarrggbb, got "FF0000"`)
+	_, err = ReadPalette(strings.NewReader("XXFF0000\n"), PaintNETPalette)
+	c.Check(err, check.ErrorMatches, `Invalid Paint.NET palette: line 1: expected aarrggbb, got "XXFF0000"`)
+}
+
+func (*PaletteSuite) TestLoadPalette(c *check.C) {
+	oct, err := LoadPalette(strings.NewReader("#ff0000 #00ff00 #0000ff"), HexPalette, 4)
+	c.Assert(err, check.IsNil)
+	c.Check(oct.count, check.Equals, uint64(3))
+	c.Check(oct.FindClosest(0xF0, 0x20, 0x10), check.Equals, value{r: 0xFF, count: 1})
+	c.Check(oct.FindClosest(0x10, 0x20, 0xA0), check.Equals, value{b: 0xFF, count: 1})
+	c.Check(oct.Palette(), check.DeepEquals, []PaletteColor{
+		{B: 0xFF, Count: 1}, {G: 0xFF, Count: 1}, {R: 0xFF, Count: 1},
+	})
+	_, err = LoadPalette(strings.NewReader("#ff0000"), HexPalette, 0)
+	c.Check(err, check.ErrorMatches, "Invalid octree depth: 0")
+	_, err = LoadPalette(strings.NewReader("red"), HexPalette, 4)
+	c.Check(err, check.ErrorMatches, `Invalid hex palette: .*`)
+	_, err = ReadPalette(strings.NewReader(""), PaletteFormat(99))
+	c.Check(err, check.ErrorMatches, "Invalid palette format: 99")
+}
+
+func (*PaletteSuite) TestPaletteColorJSON(c *check.C) {
+	data, err := json.Marshal([]PaletteColor{
+		{R: 0xFF, Count: 3},
+		{G: 0x80, Name: "Green"},
+	})
+	c.Assert(err, check.IsNil)
+	c.Check(string(data), check.Equals,
+		`[{"color":"#ff0000","count":3},{"color":"#008000","name":"Green"}]`)
+	var palette []PaletteColor
+	c.Assert(json.Unmarshal(data, &palette), check.IsNil)
+	c.Check(palette, check.DeepEquals, []PaletteColor{
+		{R: 0xFF, Count: 3},
+		{G: 0x80, Name: "Green"},
+	})
+	err = json.Unmarshal([]byte(`[{"color":"green"}]`), &palette)
+	c.Check(err, check.ErrorMatches, `Invalid hex color: "green"`)
+}
@@ -0,0 +1,180 @@
+package octree
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// Quantize reduces the colors in the tree to a palette of at most n colors.
+// Starting from the root, the block with the largest error is split into its
+// children until there are n blocks. The error of a block is how far its
+// colors are from their mean, measured with metric and weighted by count. A
+// leaf is split into its individual colors. If there isn't room for all of
+// the children of a block, the ones with the smallest error stay together.
+// Each palette color is the mean of the colors in its block, and Count is how
+// many colors were added to the block. The palette is sorted by count,
+// largest first.
+func (o *Octree) Quantize(n int, metric Metric) ([]PaletteColor, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("Invalid palette size: %d", n)
+	}
+	if o.count == 0 {
+		return []PaletteColor{}, nil
+	}
+	var final []*quantizeGroup
+	groups := quantizeHeap{o.newCursorGroup(o.rootCursor(), metric)}
+	// The number of groups, split or not
+	size := 1
+	for len(groups) > 0 {
+		g := heap.Pop(&groups).(*quantizeGroup)
+		// The slot g is using, and any that are left
+		room := n - size + 1
+		children := o.splitQuantizeGroup(g, metric)
+		if len(children) < 2 || room < 2 {
+			if len(children) == 1 {
+				// Nothing is lost by going down a level
+				heap.Push(&groups, children[0])
+				continue
+			}
+			final = append(final, g)
+			continue
+		}
+		if len(children) > room {
+			// Keep the worst children apart, and the rest together
+			slices.SortFunc(children, compareQuantizeError)
+			rest := newQuantizeGroup(metric)
+			for _, child := range children[room-1:] {
+				rest.values = append(rest.values, child.values...)
+			}
+			rest.update(metric)
+			children = append(children[:room-1], rest)
+		}
+		size += len(children) - 1
+		for _, child := range children {
+			heap.Push(&groups, child)
+		}
+	}
+	return groupPalette(final), nil
+}
+
+// A block of the tree, some of the colors of a block, or a single color, that
+// may become one color of the palette.
+type quantizeGroup struct {
+	values []*value
+	// The block that values came from, if they are all of it. Only those
+	// groups can be split.
+	c          cursor
+	splittable bool
+	// For sorting groups with the same count, the lowest Morton index of
+	// the values
+	index uint32
+	count uint64
+	sumR  float64
+	sumG  float64
+	sumB  float64
+	err   float64
+}
+
+func newQuantizeGroup(metric Metric, values ...*value) *quantizeGroup {
+	g := &quantizeGroup{values: values}
+	g.update(metric)
+	return g
+}
+
+// A group of every color in the block c.
+func (o *Octree) newCursorGroup(c cursor, metric Metric) *quantizeGroup {
+	g := &quantizeGroup{c: c}
+	o.eachLeaf(c, func(leaf []*value) {
+		g.values = append(g.values, leaf...)
+	})
+	g.splittable = len(g.values) > 1
+	g.update(metric)
+	return g
+}
+
+// Work out the totals and error of the values.
+func (g *quantizeGroup) update(metric Metric) {
+	g.index = math.MaxUint32
+	g.count = 0
+	g.sumR, g.sumG, g.sumB = 0, 0, 0
+	for _, v := range g.values {
+		count := float64(v.count)
+		g.count += v.count
+		g.sumR += count * float64(v.r)
+		g.sumG += count * float64(v.g)
+		g.sumB += count * float64(v.b)
+		g.index = min(g.index, interleaveRGB(v.r, v.g, v.b))
+	}
+	r, gr, b := g.mean()
+	g.err = 0
+	for _, v := range g.values {
+		g.err += float64(v.count) * metric.dist2(float64(v.r), float64(v.g), float64(v.b), r, gr, b)
+	}
+}
+
+func (g *quantizeGroup) mean() (r, gr, b float64) {
+	count := float64(g.count)
+	return g.sumR / count, g.sumG / count, g.sumB / count
+}
+
+func (g *quantizeGroup) color() PaletteColor {
+	r, gr, b := g.mean()
+	return PaletteColor{
+		R:     uint8(math.Round(r)),
+		G:     uint8(math.Round(gr)),
+		B:     uint8(math.Round(b)),
+		Count: g.count,
+	}
+}
+
+// The groups that g splits into, or nil if it can't be split.
+func (o *Octree) splitQuantizeGroup(g *quantizeGroup, metric Metric) []*quantizeGroup {
+	if !g.splittable {
+		return nil
+	}
+	var children []*quantizeGroup
+	if o.cursorIsLeaf(g.c) {
+		for _, v := range g.values {
+			children = append(children, newQuantizeGroup(metric, v))
+		}
+		return children
+	}
+	var buf [8]cursor
+	for _, c := range o.appendChildren(buf[:0], g.c) {
+		children = append(children, o.newCursorGroup(c, metric))
+	}
+	return children
+}
+
+// A max-heap of groups by error, implementing heap.Interface.
+type quantizeHeap []*quantizeGroup
+
+func (h quantizeHeap) Len() int { return len(h) }
+
+func (h quantizeHeap) Less(i, j int) bool {
+	return compareQuantizeError(h[i], h[j]) < 0
+}
+
+// Larger errors sort first.
+func compareQuantizeError(x, y *quantizeGroup) int {
+	if x.err != y.err {
+		if x.err > y.err {
+			return -1
+		}
+		return 1
+	}
+	return int(x.index) - int(y.index)
+}
+
+func (h quantizeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *quantizeHeap) Push(x any) { *h = append(*h, x.(*quantizeGroup)) }
+
+func (h *quantizeHeap) Pop() any {
+	old := *h
+	g := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return g
+}
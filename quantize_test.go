@@ -0,0 +1,111 @@
+package octree
+
+import (
+	"math/rand"
+
+	"gopkg.in/check.v1"
+)
+
+type QuantizeSuite struct{}
+
+var _ = check.Suite(&QuantizeSuite{})
+
+func (*QuantizeSuite) TestQuantizeEmpty(c *check.C) {
+	oct, err := NewOctree(4)
+	c.Assert(err, check.IsNil)
+	palette, err := oct.Quantize(4, EuclideanMetric)
+	c.Assert(err, check.IsNil)
+	c.Check(palette, check.HasLen, 0)
+	_, err = oct.Quantize(0, EuclideanMetric)
+	c.Check(err, check.ErrorMatches, "Invalid palette size: 0")
+}
+
+func (*QuantizeSuite) TestQuantizeFewColors(c *check.C) {
+	// With room for every color, we get them back exactly
+	for _, options := range [][]Option{nil, {WithStorage(SparseStorage)}, {WithAdaptiveLeaves(2)}} {
+		oct, err := NewOctree(3, options...)
+		c.Assert(err, check.IsNil)
+		oct.Add(0x10, 0x20, 0x30)
+		oct.Add(0x10, 0x20, 0x30)
+		oct.Add(0x11, 0x20, 0x30)
+		oct.Add(0xF0, 0x00, 0x00)
+		palette, err := oct.Quantize(3, EuclideanMetric)
+		c.Assert(err, check.IsNil)
+		c.Check(palette, check.DeepEquals, []PaletteColor{
+			{R: 0x10, G: 0x20, B: 0x30, Count: 2},
+			{R: 0x11, G: 0x20, B: 0x30, Count: 1},
+			{R: 0xF0, Count: 1},
+		})
+		// With one less, the two closest colors are merged
+		palette, err = oct.Quantize(2, EuclideanMetric)
+		c.Assert(err, check.IsNil)
+		c.Check(palette, check.DeepEquals, []PaletteColor{
+			{R: 0x10, G: 0x20, B: 0x30, Count: 3},
+			{R: 0xF0, Count: 1},
+		})
+		palette, err = oct.Quantize(1, EuclideanMetric)
+		c.Assert(err, check.IsNil)
+		c.Check(palette, check.DeepEquals, []PaletteColor{
+			// The mean of all of them
+			{R: 0x48, G: 0x18, B: 0x24, Count: 4},
+		})
+	}
+}
+
+func (*QuantizeSuite) TestQuantizeSplitsLargestError(c *check.C) {
+	oct, err := NewOctree(4)
+	c.Assert(err, check.IsNil)
+	// A tight, common cluster, and a loose, rare one
+	for i := 0; i < 100; i++ {
+		oct.Add(0x00, 0x00, uint8(i%4))
+	}
+	oct.Add(0xFF, 0x00, 0x00)
+	oct.Add(0xFF, 0xFF, 0x00)
+	palette, err := oct.Quantize(3, EuclideanMetric)
+	c.Assert(err, check.IsNil)
+	// The loose cluster is split, even though it only has 2 colors
+	c.Check(palette, check.DeepEquals, []PaletteColor{
+		{R: 0x00, G: 0x00, B: 0x02, Count: 100},
+		{R: 0xFF, Count: 1},
+		{R: 0xFF, G: 0xFF, Count: 1},
+	})
+}
+
+func (*QuantizeSuite) TestQuantizeLimit(c *check.C) {
+	rng := rand.New(rand.NewSource(1))
+	for _, metric := range []Metric{EuclideanMetric, RedmeanMetric} {
+		oct, err := NewOctree(5)
+		c.Assert(err, check.IsNil)
+		for i := 0; i < 2000; i++ {
+			oct.Add(uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)))
+		}
+		for _, n := range []int{1, 7, 16, 100} {
+			palette, err := oct.Quantize(n, metric)
+			c.Assert(err, check.IsNil)
+			c.Check(palette, check.HasLen, n)
+			total := uint64(0)
+			for i, p := range palette {
+				total += p.Count
+				if i > 0 {
+					c.Check(p.Count <= palette[i-1].Count, check.Equals, true)
+				}
+			}
+			c.Check(total, check.Equals, oct.count)
+		}
+	}
+}
+
+func (*QuantizeSuite) TestMetric(c *check.C) {
+	c.Check(EuclideanMetric.dist2(1, 2, 3, 4, 6, 3), check.Equals, 25.0)
+	// Green counts double, and blue counts for more in dark colors
+	c.Check(RedmeanMetric.dist2(0, 0, 0, 0, 1, 0), check.Equals, 4.0)
+	c.Check(RedmeanMetric.dist2(0, 0, 0, 0, 0, 1) > RedmeanMetric.dist2(0, 0, 0, 1, 0, 0),
+		check.Equals, true)
+	for _, metric := range []Metric{EuclideanMetric, RedmeanMetric} {
+		parsed, err := ParseMetric(metric.String())
+		c.Check(err, check.IsNil)
+		c.Check(parsed, check.Equals, metric)
+	}
+	_, err := ParseMetric("cie2000")
+	c.Check(err, check.ErrorMatches, `Unknown metric: "cie2000"`)
+}
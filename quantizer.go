@@ -0,0 +1,95 @@
+package octree
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Quantizer selects the algorithm that QuantizeWith uses to reduce the colors
+// of a tree to a palette. They all read the distinct colors and counts held
+// in the leaves of the tree.
+type Quantizer int
+
+const (
+	// OctreeQuantizer splits the blocks of the tree, as Quantize does.
+	OctreeQuantizer Quantizer = iota
+	// MedianCutQuantizer repeatedly splits the box of colors with the
+	// largest error at the weighted median of its longest side.
+	MedianCutQuantizer
+	// WuQuantizer is Xiaolin Wu's quantizer, which cuts boxes of a 32x32x32
+	// histogram where the cut leaves the least variance.
+	WuQuantizer
+)
+
+func (q Quantizer) String() string {
+	switch q {
+	case OctreeQuantizer:
+		return "octree"
+	case MedianCutQuantizer:
+		return "mediancut"
+	case WuQuantizer:
+		return "wu"
+	}
+	return fmt.Sprintf("Quantizer(%d)", int(q))
+}
+
+// ParseQuantizer finds the Quantizer with the given name, as returned by
+// String.
+func ParseQuantizer(name string) (Quantizer, error) {
+	switch strings.ToLower(name) {
+	case "octree":
+		return OctreeQuantizer, nil
+	case "mediancut":
+		return MedianCutQuantizer, nil
+	case "wu":
+		return WuQuantizer, nil
+	}
+	return 0, fmt.Errorf("Unknown quantizer: %q", name)
+}
+
+// QuantizeWith reduces the colors in the tree to a palette of at most n
+// colors with quantizer. Whichever is used, each palette color is the mean
+// of the colors it stands for, Count is how many colors were added to them,
+// and the palette is sorted by count, largest first, as with Quantize.
+func (o *Octree) QuantizeWith(quantizer Quantizer, n int, metric Metric) ([]PaletteColor, error) {
+	switch quantizer {
+	case OctreeQuantizer:
+		return o.Quantize(n, metric)
+	case MedianCutQuantizer, WuQuantizer:
+	default:
+		return nil, fmt.Errorf("Invalid quantizer: %d", int(quantizer))
+	}
+	if n < 1 {
+		return nil, fmt.Errorf("Invalid palette size: %d", n)
+	}
+	if o.count == 0 {
+		return []PaletteColor{}, nil
+	}
+	var values []*value
+	o.eachLeaf(o.rootCursor(), func(leaf []*value) {
+		values = append(values, leaf...)
+	})
+	if quantizer == MedianCutQuantizer {
+		return groupPalette(medianCut(values, n, metric)), nil
+	}
+	return groupPalette(wuQuantize(values, n, metric)), nil
+}
+
+// The colors of the groups, largest count first, and then in Morton order.
+func groupPalette(groups []*quantizeGroup) []PaletteColor {
+	slices.SortFunc(groups, func(x, y *quantizeGroup) int {
+		if x.count != y.count {
+			if x.count > y.count {
+				return -1
+			}
+			return 1
+		}
+		return int(x.index) - int(y.index)
+	})
+	palette := make([]PaletteColor, len(groups))
+	for i, g := range groups {
+		palette[i] = g.color()
+	}
+	return palette
+}
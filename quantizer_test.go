@@ -0,0 +1,91 @@
+package octree
+
+import (
+	"math/rand"
+
+	"gopkg.in/check.v1"
+)
+
+type QuantizerSuite struct{}
+
+var _ = check.Suite(&QuantizerSuite{})
+
+var allQuantizers = []Quantizer{OctreeQuantizer, MedianCutQuantizer, WuQuantizer}
+
+func (*QuantizerSuite) TestParseQuantizer(c *check.C) {
+	for _, q := range allQuantizers {
+		parsed, err := ParseQuantizer(q.String())
+		c.Check(err, check.IsNil)
+		c.Check(parsed, check.Equals, q)
+	}
+	_, err := ParseQuantizer("neuquant")
+	c.Check(err, check.ErrorMatches, `Unknown quantizer: "neuquant"`)
+}
+
+func (*QuantizerSuite) TestQuantizeWithFewColors(c *check.C) {
+	for _, options := range [][]Option{nil, {WithStorage(SparseStorage)}, {WithAdaptiveLeaves(2)}} {
+		oct, err := NewOctree(3, options...)
+		c.Assert(err, check.IsNil)
+		oct.Add(0x10, 0x20, 0x30)
+		oct.Add(0x10, 0x20, 0x30)
+		oct.Add(0x80, 0x80, 0x80)
+		oct.Add(0xF0, 0x00, 0x00)
+		for _, q := range allQuantizers {
+			// With room for every color, we get them back exactly
+			palette, err := oct.QuantizeWith(q, 4, EuclideanMetric)
+			c.Assert(err, check.IsNil)
+			c.Check(palette, check.DeepEquals, []PaletteColor{
+				{R: 0x10, G: 0x20, B: 0x30, Count: 2},
+				{R: 0xF0, Count: 1},
+				{R: 0x80, G: 0x80, B: 0x80, Count: 1},
+			}, check.Commentf("%s", q))
+			palette, err = oct.QuantizeWith(q, 1, RedmeanMetric)
+			c.Assert(err, check.IsNil)
+			c.Check(palette, check.DeepEquals, []PaletteColor{
+				{R: 0x64, G: 0x30, B: 0x38, Count: 4},
+			}, check.Commentf("%s", q))
+		}
+	}
+}
+
+func (*QuantizerSuite) TestQuantizeWith(c *check.C) {
+	rng := rand.New(rand.NewSource(1))
+	oct, err := NewOctree(6)
+	c.Assert(err, check.IsNil)
+	for i := 0; i < 2000; i++ {
+		oct.Add(uint8(rng.Intn(256)), uint8(rng.NormFloat64()*40+100), uint8(rng.Intn(64)))
+	}
+	for _, q := range allQuantizers {
+		palette, err := oct.QuantizeWith(q, 16, EuclideanMetric)
+		c.Assert(err, check.IsNil)
+		c.Check(palette, check.HasLen, 16, check.Commentf("%s", q))
+		total := uint64(0)
+		for i, p := range palette {
+			total += p.Count
+			if i > 0 {
+				c.Check(p.Count <= palette[i-1].Count, check.Equals, true)
+			}
+		}
+		c.Check(total, check.Equals, oct.count, check.Commentf("%s", q))
+		// Each of them does much better than a single color
+		_, single, err := oct.Refine([]PaletteColor{{R: 0x80, G: 0x64, B: 0x20}}, EuclideanMetric, 0)
+		c.Assert(err, check.IsNil)
+		_, quantized, err := oct.Refine(palette, EuclideanMetric, 0)
+		c.Assert(err, check.IsNil)
+		c.Check(quantized < single/4, check.Equals, true, check.Commentf("%s: %v", q, quantized))
+	}
+}
+
+func (*QuantizerSuite) TestQuantizeWithErrors(c *check.C) {
+	oct, err := NewOctree(3)
+	c.Assert(err, check.IsNil)
+	for _, q := range allQuantizers {
+		palette, err := oct.QuantizeWith(q, 4, EuclideanMetric)
+		c.Assert(err, check.IsNil)
+		c.Check(palette, check.HasLen, 0)
+		_, err = oct.QuantizeWith(q, 0, EuclideanMetric)
+		c.Check(err, check.ErrorMatches, "Invalid palette size: 0")
+	}
+	_, err = oct.QuantizeWith(Quantizer(7), 4, EuclideanMetric)
+	c.Check(err, check.ErrorMatches, "Invalid quantizer: 7")
+}
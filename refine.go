@@ -0,0 +1,176 @@
+package octree
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// Refine improves a palette, such as one from Quantize, with weighted k-means
+// (Lloyd's algorithm). Every distinct color of the tree is a sample, weighted
+// by its count. Each iteration assigns every sample to the nearest palette
+// color, and then moves each palette color to the mean of its samples,
+// rounded to the nearest color. A palette color that no samples are nearest
+// to is moved to the sample with the largest error, its distance from its
+// own palette color times its count, that isn't already a palette color. It
+// stops after the given number of iterations, or sooner once nothing moves.
+//
+// With EuclideanMetric, the nearest palette color is found by searching an
+// Octree of the palette, otherwise every palette color is tried.
+//
+// The refined palette is in the same order as palette, with Count set to
+// the total count of the samples nearest to each color. The error is the sum
+// over all samples of the distance to their nearest palette color, measured
+// with metric, times their count.
+func (o *Octree) Refine(palette []PaletteColor, metric Metric, iterations int) ([]PaletteColor, float64, error) {
+	if len(palette) == 0 {
+		return nil, 0, fmt.Errorf("Invalid palette size: %d", len(palette))
+	}
+	if iterations < 0 {
+		return nil, 0, fmt.Errorf("Invalid iteration count: %d", iterations)
+	}
+	var samples []*value
+	o.eachLeaf(o.rootCursor(), func(leaf []*value) {
+		samples = append(samples, leaf...)
+	})
+	refined := slices.Clone(palette)
+	for i := 0; i < iterations; i++ {
+		a, err := assignSamples(samples, refined, metric)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !a.moveColors(refined) {
+			break
+		}
+	}
+	a, err := assignSamples(samples, refined, metric)
+	if err != nil {
+		return nil, 0, err
+	}
+	total := 0.0
+	for i := range refined {
+		refined[i].Count = a.counts[i]
+	}
+	for _, e := range a.errs {
+		total += e
+	}
+	return refined, total, nil
+}
+
+// Which palette color each sample is nearest to.
+type sampleAssignment struct {
+	samples []*value
+	// The index of the nearest palette color to each sample, and the error
+	// of the sample, its distance times its count
+	nearest []int
+	errs    []float64
+	// The totals of the samples nearest to each palette color
+	counts           []uint64
+	sumR, sumG, sumB []float64
+}
+
+func assignSamples(samples []*value, palette []PaletteColor, metric Metric) (*sampleAssignment, error) {
+	a := &sampleAssignment{
+		samples: samples,
+		nearest: make([]int, len(samples)),
+		errs:    make([]float64, len(samples)),
+		counts:  make([]uint64, len(palette)),
+		sumR:    make([]float64, len(palette)),
+		sumG:    make([]float64, len(palette)),
+		sumB:    make([]float64, len(palette)),
+	}
+	find := func(v *value) int {
+		best, bestDist2 := 0, math.Inf(1)
+		for i, p := range palette {
+			dist2 := metric.dist2(float64(v.r), float64(v.g), float64(v.b),
+				float64(p.R), float64(p.G), float64(p.B))
+			if dist2 < bestDist2 {
+				best, bestDist2 = i, dist2
+			}
+		}
+		return best
+	}
+	if metric == EuclideanMetric {
+		// Adaptive leaves keep the search fast however the palette is spread
+		tree, err := NewOctree(maxSparseDepth, WithAdaptiveLeaves(8))
+		if err != nil {
+			return nil, err
+		}
+		index := make(map[uint32]int, len(palette))
+		for i, p := range palette {
+			rgb := packRGB(p.R, p.G, p.B)
+			if _, ok := index[rgb]; !ok {
+				index[rgb] = i
+				tree.Add(p.R, p.G, p.B)
+			}
+		}
+		find = func(v *value) int {
+			closest := tree.FindClosest(v.r, v.g, v.b)
+			return index[packRGB(closest.r, closest.g, closest.b)]
+		}
+	}
+	for i, v := range samples {
+		nearest := find(v)
+		p := palette[nearest]
+		count := float64(v.count)
+		a.nearest[i] = nearest
+		a.errs[i] = count * metric.dist2(float64(v.r), float64(v.g), float64(v.b),
+			float64(p.R), float64(p.G), float64(p.B))
+		a.counts[nearest] += v.count
+		a.sumR[nearest] += count * float64(v.r)
+		a.sumG[nearest] += count * float64(v.g)
+		a.sumB[nearest] += count * float64(v.b)
+	}
+	return a, nil
+}
+
+// Move each palette color to the mean of its samples. Returns whether any of
+// them moved.
+func (a *sampleAssignment) moveColors(palette []PaletteColor) bool {
+	moved := false
+	var empty []int
+	for i := range palette {
+		if a.counts[i] == 0 {
+			empty = append(empty, i)
+			continue
+		}
+		count := float64(a.counts[i])
+		r := uint8(math.Round(a.sumR[i] / count))
+		g := uint8(math.Round(a.sumG[i] / count))
+		b := uint8(math.Round(a.sumB[i] / count))
+		if r != palette[i].R || g != palette[i].G || b != palette[i].B {
+			palette[i].R, palette[i].G, palette[i].B = r, g, b
+			moved = true
+		}
+	}
+	if len(empty) == 0 {
+		return moved
+	}
+	// Give the unused colors to the worst served samples
+	worst := make([]int, len(a.samples))
+	for i := range worst {
+		worst[i] = i
+	}
+	slices.SortStableFunc(worst, func(x, y int) int {
+		return cmp.Compare(a.errs[y], a.errs[x])
+	})
+	used := make(map[uint32]bool, len(palette))
+	for _, p := range palette {
+		used[packRGB(p.R, p.G, p.B)] = true
+	}
+	for _, i := range worst {
+		if len(empty) == 0 || a.errs[i] == 0 {
+			break
+		}
+		v := a.samples[i]
+		if used[packRGB(v.r, v.g, v.b)] {
+			continue
+		}
+		used[packRGB(v.r, v.g, v.b)] = true
+		palette[empty[0]].R, palette[empty[0]].G, palette[empty[0]].B = v.r, v.g, v.b
+		empty = empty[1:]
+		moved = true
+	}
+	return moved
+}
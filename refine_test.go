@@ -0,0 +1,84 @@
+package octree
+
+import (
+	"math/rand"
+
+	"gopkg.in/check.v1"
+)
+
+type RefineSuite struct{}
+
+var _ = check.Suite(&RefineSuite{})
+
+func (*RefineSuite) TestRefine(c *check.C) {
+	oct, err := NewOctree(4)
+	c.Assert(err, check.IsNil)
+	oct.addCount(0x10, 0x10, 0x10, 3)
+	oct.addCount(0x14, 0x10, 0x10, 1)
+	oct.addCount(0xC0, 0xC0, 0xC0, 1)
+	oct.addCount(0xC0, 0xC0, 0xC8, 1)
+	for _, metric := range []Metric{EuclideanMetric, RedmeanMetric} {
+		start := []PaletteColor{{Name: "dark"}, {R: 5, G: 5, B: 5, Name: "light"}}
+		// No iterations just measures the palette
+		palette, total, err := oct.Refine(start, metric, 0)
+		c.Assert(err, check.IsNil)
+		c.Check(palette, check.DeepEquals, []PaletteColor{
+			{Name: "dark"}, {R: 5, G: 5, B: 5, Name: "light", Count: 6},
+		})
+		c.Check(total > 0, check.Equals, true)
+		palette, total, err = oct.Refine(start, metric, 10)
+		c.Assert(err, check.IsNil)
+		// "dark" had nothing nearest to it, so it took the worst color
+		c.Check(palette, check.DeepEquals, []PaletteColor{
+			{R: 0xC0, G: 0xC0, B: 0xC4, Name: "dark", Count: 2},
+			{R: 0x11, G: 0x10, B: 0x10, Name: "light", Count: 4},
+		})
+		expected := 3*metric.dist2(0x10, 0x10, 0x10, 0x11, 0x10, 0x10) +
+			metric.dist2(0x14, 0x10, 0x10, 0x11, 0x10, 0x10) +
+			metric.dist2(0xC0, 0xC0, 0xC0, 0xC0, 0xC0, 0xC4) +
+			metric.dist2(0xC0, 0xC0, 0xC8, 0xC0, 0xC0, 0xC4)
+		c.Check(total, check.Equals, expected)
+		// The palette passed in is left alone
+		c.Check(start[0], check.Equals, PaletteColor{Name: "dark"})
+	}
+}
+
+func (*RefineSuite) TestRefineQuantized(c *check.C) {
+	rng := rand.New(rand.NewSource(1))
+	oct, err := NewOctree(6)
+	c.Assert(err, check.IsNil)
+	for i := 0; i < 2000; i++ {
+		oct.Add(uint8(rng.NormFloat64()*30+80), uint8(rng.Intn(256)), uint8(rng.NormFloat64()*20+160))
+	}
+	quantized, err := oct.Quantize(8, EuclideanMetric)
+	c.Assert(err, check.IsNil)
+	_, before, err := oct.Refine(quantized, EuclideanMetric, 0)
+	c.Assert(err, check.IsNil)
+	refined, after, err := oct.Refine(quantized, EuclideanMetric, 20)
+	c.Assert(err, check.IsNil)
+	c.Check(refined, check.HasLen, 8)
+	c.Check(after < before, check.Equals, true, check.Commentf("%v then %v", before, after))
+	total := uint64(0)
+	for _, p := range refined {
+		total += p.Count
+	}
+	c.Check(total, check.Equals, oct.count)
+	// The octree search finds the same colors as trying them all
+	_, brute, err := oct.Refine(refined, EuclideanMetric, 0)
+	c.Assert(err, check.IsNil)
+	c.Check(brute, check.Equals, after)
+}
+
+func (*RefineSuite) TestRefineErrors(c *check.C) {
+	oct, err := NewOctree(3)
+	c.Assert(err, check.IsNil)
+	_, _, err = oct.Refine(nil, EuclideanMetric, 1)
+	c.Check(err, check.ErrorMatches, "Invalid palette size: 0")
+	_, _, err = oct.Refine([]PaletteColor{{}}, EuclideanMetric, -1)
+	c.Check(err, check.ErrorMatches, "Invalid iteration count: -1")
+	// An empty tree leaves the palette where it is
+	palette, total, err := oct.Refine([]PaletteColor{{R: 1}}, EuclideanMetric, 5)
+	c.Assert(err, check.IsNil)
+	c.Check(palette, check.DeepEquals, []PaletteColor{{R: 1}})
+	c.Check(total, check.Equals, 0.0)
+}
@@ -0,0 +1,35 @@
+package octree
+
+import (
+	"maps"
+)
+
+// Resample makes a new tree of the given depth holding the same colors as o,
+// with the same options. Colors are kept exactly, so nothing is lost going to
+// a shallower tree and back. The new tree is sparse if o is, or if it is too
+// deep to be dense, and dense otherwise. A decaying tree keeps how much an
+// Add counts for, and a windowed one keeps the colors of each frame.
+func (o *Octree) Resample(depth int) (*Octree, error) {
+	storage := DenseStorage
+	if o.root != nil || o.maxLeafSize > 0 || depth > maxDenseDepth {
+		storage = SparseStorage
+	}
+	tree, err := NewOctree(depth, append(o.options(), WithStorage(storage))...)
+	if err != nil {
+		return nil, err
+	}
+	o.eachLeaf(o.rootCursor(), func(values []*value) {
+		for _, v := range values {
+			tree.addCount(v.r, v.g, v.b, v.count)
+		}
+	})
+	tree.saturated = o.saturated
+	tree.decayWeight = o.decayWeight
+	if o.window != nil {
+		tree.window.frames = nil
+		for _, frame := range o.window.frames {
+			tree.window.frames = append(tree.window.frames, maps.Clone(frame))
+		}
+	}
+	return tree, nil
+}
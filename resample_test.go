@@ -0,0 +1,87 @@
+package octree
+
+import (
+	"math/rand"
+	"slices"
+
+	"gopkg.in/check.v1"
+)
+
+type ResampleSuite struct{}
+
+var _ = check.Suite(&ResampleSuite{})
+
+func (*ResampleSuite) TestResample(c *check.C) {
+	rng := rand.New(rand.NewSource(1))
+	for _, options := range [][]Option{
+		nil,
+		{WithOrdering(HilbertOrder)},
+		{WithStorage(SparseStorage)},
+		{WithAdaptiveLeaves(4)},
+		{WithNodeStats(), WithCounts64()},
+	} {
+		oct, err := NewOctree(3, options...)
+		c.Assert(err, check.IsNil)
+		for i := 0; i < 500; i++ {
+			oct.Add(uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)))
+		}
+		entries := slices.Collect(oct.Entries())
+		for _, depth := range []int{1, 2, 5, 8, 9} {
+			resampled, err := oct.Resample(depth)
+			c.Assert(err, check.IsNil)
+			c.Check(resampled.Depth(), check.Equals, depth)
+			c.Check(resampled.ordering, check.Equals, oct.ordering)
+			c.Check(resampled.maxLeafSize, check.Equals, oct.maxLeafSize)
+			c.Check(resampled.maxCount, check.Equals, oct.maxCount)
+			c.Check(resampled.root != nil, check.Equals,
+				oct.root != nil || depth > maxDenseDepth)
+			c.Check(resampled.count, check.Equals, oct.count)
+			c.Check(slices.Collect(resampled.Entries()), check.DeepEquals, entries)
+			checkLayersAgree(c, resampled)
+			for i := 0; i < 20; i++ {
+				r, g, b := uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256))
+				found := resampled.FindClosest(r, g, b)
+				expected := oct.FindClosest(r, g, b)
+				c.Check(dist2ToV(r, g, b, &found), check.Equals, dist2ToV(r, g, b, &expected))
+			}
+			stats, ok := resampled.NodeStats(Node{})
+			c.Assert(ok, check.Equals, true)
+			expected, _ := oct.NodeStats(Node{})
+			c.Check(stats, check.DeepEquals, expected)
+		}
+	}
+}
+
+func (*ResampleSuite) TestResampleTime(c *check.C) {
+	decaying, err := NewOctree(3, WithDecay(0.5))
+	c.Assert(err, check.IsNil)
+	decaying.Tick()
+	decaying.Add(1, 2, 3)
+	resampled, err := decaying.Resample(5)
+	c.Assert(err, check.IsNil)
+	c.Check(resampled.AddWeight(), check.Equals, decaying.AddWeight())
+	resampled.Add(1, 2, 3)
+	c.Check(resampled.count, check.Equals, uint64(1024))
+
+	windowed, err := NewOctree(3, WithWindow(2))
+	c.Assert(err, check.IsNil)
+	windowed.Add(1, 2, 3)
+	windowed.Tick()
+	windowed.Add(4, 5, 6)
+	resampled, err = windowed.Resample(2)
+	c.Assert(err, check.IsNil)
+	resampled.Tick()
+	c.Check(slices.Collect(resampled.Entries()), check.DeepEquals,
+		[]value{{r: 4, g: 5, b: 6, count: 1}})
+	// The original keeps its own frames
+	c.Check(windowed.count, check.Equals, uint64(2))
+}
+
+func (*ResampleSuite) TestResampleErrors(c *check.C) {
+	oct, err := NewOctree(3)
+	c.Assert(err, check.IsNil)
+	_, err = oct.Resample(0)
+	c.Check(err, check.ErrorMatches, "Invalid octree depth: 0")
+	_, err = oct.Resample(10)
+	c.Check(err, check.ErrorMatches, "Invalid octree depth: 10")
+}
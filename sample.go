@@ -0,0 +1,167 @@
+package octree
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/rand"
+	"slices"
+)
+
+// A Sampler adds a random sample of the pixels of images to a tree, rather
+// than every pixel. Each image is split into square tiles, and the same
+// fraction of every tile is sampled, so that no part of an image can be
+// missed by chance. The counts in the tree are of the sampled pixels, and
+// Estimates scales them back up to all of the pixels.
+type Sampler struct {
+	tree     *Octree
+	rate     float64
+	tileSize int
+	rng      *rand.Rand
+	// The number of pixels offered, and the number sampled
+	pixels, sampled uint64
+}
+
+// NewSampler makes a Sampler that adds about rate of the pixels of every
+// tileSize x tileSize tile to tree. rate must be above 0, and no more than 1.
+// The pixels sampled only depend on seed and the sizes of the images, so the
+// same images always give the same tree.
+func NewSampler(tree *Octree, rate float64, tileSize int, seed int64) (*Sampler, error) {
+	if !(rate > 0 && rate <= 1) {
+		return nil, fmt.Errorf("Invalid sample rate: %v", rate)
+	}
+	if tileSize < 1 {
+		return nil, fmt.Errorf("Invalid tile size: %d", tileSize)
+	}
+	return &Sampler{
+		tree:     tree,
+		rate:     rate,
+		tileSize: tileSize,
+		rng:      rand.New(rand.NewSource(seed)),
+	}, nil
+}
+
+// AddImage samples the pixels of img. A tile of n pixels has n*rate of them
+// sampled, rounded up or down at random so that on average it is exactly
+// n*rate. Fully transparent pixels have no color, so they are sampled like
+// any other, but not added to the tree.
+func (s *Sampler) AddImage(img image.Image) {
+	bounds := img.Bounds()
+	for y0 := bounds.Min.Y; y0 < bounds.Max.Y; y0 += s.tileSize {
+		for x0 := bounds.Min.X; x0 < bounds.Max.X; x0 += s.tileSize {
+			tile := image.Rect(x0, y0, x0+s.tileSize, y0+s.tileSize).Intersect(bounds)
+			s.addTile(img, tile)
+		}
+	}
+}
+
+func (s *Sampler) addTile(img image.Image, tile image.Rectangle) {
+	n := tile.Dx() * tile.Dy()
+	want := float64(n) * s.rate
+	k := int(want)
+	if s.rng.Float64() < want-float64(k) {
+		k++
+	}
+	s.pixels += uint64(n)
+	s.sampled += uint64(k)
+	for _, i := range reservoirSample(s.rng, n, k) {
+		x, y := tile.Min.X+i%tile.Dx(), tile.Min.Y+i/tile.Dx()
+		if r, g, b, ok := PixelRGB(img, x, y); ok {
+			s.tree.Add(r, g, b)
+		}
+	}
+}
+
+// Choose k of the indexes [0, n) uniformly at random, returned in order. This
+// is Li's reservoir sampling "Algorithm L", which skips over the indexes that
+// won't be chosen, so it only takes O(k(1 + log(n/k))) random numbers.
+func reservoirSample(rng *rand.Rand, n, k int) []int {
+	if k <= 0 {
+		return nil
+	}
+	reservoir := make([]int, k)
+	for i := range reservoir {
+		reservoir[i] = i
+	}
+	// 1-Float64 is in (0, 1], so the logs are never of 0
+	w := math.Exp(math.Log(1-rng.Float64()) / float64(k))
+	for i := float64(k - 1); ; {
+		i += math.Floor(math.Log(1-rng.Float64())/math.Log(1-w)) + 1
+		if i >= float64(n) {
+			break
+		}
+		reservoir[rng.Intn(k)] = int(i)
+		w *= math.Exp(math.Log(1-rng.Float64()) / float64(k))
+	}
+	slices.Sort(reservoir)
+	return reservoir
+}
+
+// PixelRGB is the 8 bit color of the pixel of img at x,y, with the alpha
+// premultiplication undone, and false if the pixel is fully transparent.
+func PixelRGB(img image.Image, x, y int) (r, g, b uint8, ok bool) {
+	r32, g32, b32, a32 := img.At(x, y).RGBA()
+	if a32 == 0 {
+		return 0, 0, 0, false
+	}
+	if a32 < 0xFFFF {
+		r32 = r32 * 0xFFFF / a32
+		g32 = g32 * 0xFFFF / a32
+		b32 = b32 * 0xFFFF / a32
+	}
+	return uint8(r32 >> 8), uint8(g32 >> 8), uint8(b32 >> 8), true
+}
+
+// Pixels is the number of pixels of all of the images added, sampled or not.
+func (s *Sampler) Pixels() uint64 {
+	return s.pixels
+}
+
+// Sampled is the number of pixels that were sampled, including any that were
+// transparent.
+func (s *Sampler) Sampled() uint64 {
+	return s.sampled
+}
+
+// Estimate is how many pixels of one color the sampled images hold.
+type Estimate struct {
+	R, G, B uint8
+	// The number of pixels of the color that were sampled
+	Sampled uint64
+	// Sampled scaled up by the sample rate, which is unbiased: on average
+	// over all seeds, it is exactly the number of pixels of the color
+	Count float64
+	// The confidence interval for the number of pixels of the color
+	Low, High float64
+}
+
+// Estimates scales up the counts of the n most frequent colors of the tree,
+// most frequent first, with the interval that the true count is inside with
+// the given confidence, such as 0.95. The intervals use the normal
+// approximation for sampling each pixel independently with probability rate,
+// which is if anything too wide, as sampling the same fraction of every tile
+// can only reduce the variance. They never go below the number of pixels
+// that were seen, and have no width if every pixel was sampled. confidence
+// must be above 0 and below 1.
+func (s *Sampler) Estimates(n int, confidence float64) ([]Estimate, error) {
+	if !(confidence > 0 && confidence < 1) {
+		return nil, fmt.Errorf("Invalid confidence: %v", confidence)
+	}
+	z := math.Sqrt2 * math.Erfinv(confidence)
+	var estimates []Estimate
+	for _, v := range s.tree.MostFrequent(n) {
+		sampled := float64(v.count)
+		count := sampled / s.rate
+		// The variance of the count of a color sampled with probability
+		// rate is count*rate*(1-rate), scaled up by 1/rate^2
+		margin := z * math.Sqrt(sampled*(1-s.rate)) / s.rate
+		estimates = append(estimates, Estimate{
+			R: v.r, G: v.g, B: v.b,
+			Sampled: v.count,
+			Count:   count,
+			Low:     max(count-margin, sampled),
+			High:    min(count+margin, float64(s.pixels)),
+		})
+	}
+	return estimates, nil
+}
@@ -0,0 +1,151 @@
+package octree
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+
+	"gopkg.in/check.v1"
+)
+
+type SampleSuite struct{}
+
+var _ = check.Suite(&SampleSuite{})
+
+// An image whose first redRows rows are red, and the rest blue, with the
+// top left pixel transparent.
+func sampleImage(width, height, redRows int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if y < redRows {
+				img.Set(x, y, color.NRGBA{R: 0xFF, A: 0xFF})
+			} else {
+				img.Set(x, y, color.NRGBA{B: 0xFF, A: 0xFF})
+			}
+		}
+	}
+	img.Set(0, 0, color.NRGBA{})
+	return img
+}
+
+func (*SampleSuite) TestNewSamplerErrors(c *check.C) {
+	oct, err := NewOctree(3)
+	c.Assert(err, check.IsNil)
+	_, err = NewSampler(oct, 0, 8, 1)
+	c.Check(err, check.ErrorMatches, "Invalid sample rate: 0")
+	_, err = NewSampler(oct, 1.5, 8, 1)
+	c.Check(err, check.ErrorMatches, "Invalid sample rate: 1.5")
+	_, err = NewSampler(oct, 0.5, 0, 1)
+	c.Check(err, check.ErrorMatches, "Invalid tile size: 0")
+}
+
+func (*SampleSuite) TestReservoirSample(c *check.C) {
+	rng := rand.New(rand.NewSource(1))
+	c.Check(reservoirSample(rng, 10, 0), check.HasLen, 0)
+	c.Check(reservoirSample(rng, 4, 4), check.DeepEquals, []int{0, 1, 2, 3})
+	hits := make([]int, 10)
+	for i := 0; i < 10000; i++ {
+		chosen := reservoirSample(rng, 10, 3)
+		c.Assert(chosen, check.HasLen, 3)
+		for j, index := range chosen {
+			if j > 0 {
+				c.Assert(index > chosen[j-1], check.Equals, true)
+			}
+			hits[index]++
+		}
+	}
+	for _, h := range hits {
+		// Each index should be chosen 3000 times
+		c.Check(h > 2800 && h < 3200, check.Equals, true, check.Commentf("%v", hits))
+	}
+}
+
+func (*SampleSuite) TestSampleEverything(c *check.C) {
+	oct, err := NewOctree(3)
+	c.Assert(err, check.IsNil)
+	s, err := NewSampler(oct, 1, 7, 1)
+	c.Assert(err, check.IsNil)
+	s.AddImage(sampleImage(20, 10, 3))
+	c.Check(s.Pixels(), check.Equals, uint64(200))
+	c.Check(s.Sampled(), check.Equals, uint64(200))
+	c.Check(oct.count, check.Equals, uint64(199))
+	estimates, err := s.Estimates(5, 0.95)
+	c.Assert(err, check.IsNil)
+	c.Check(estimates, check.DeepEquals, []Estimate{
+		{B: 0xFF, Sampled: 140, Count: 140, Low: 140, High: 140},
+		{R: 0xFF, Sampled: 59, Count: 59, Low: 59, High: 59},
+	})
+}
+
+func (*SampleSuite) TestEstimatesInvalidConfidence(c *check.C) {
+	oct, err := NewOctree(3)
+	c.Assert(err, check.IsNil)
+	s, err := NewSampler(oct, 0.5, 4, 1)
+	c.Assert(err, check.IsNil)
+	s.AddImage(sampleImage(8, 8, 2))
+	for _, confidence := range []float64{0, 1, -1, 1.5, -0.5, math.NaN()} {
+		estimates, err := s.Estimates(3, confidence)
+		c.Check(err, check.ErrorMatches, "Invalid confidence: .*")
+		c.Check(estimates, check.IsNil)
+	}
+}
+
+func (*SampleSuite) TestSampleEstimates(c *check.C) {
+	img := sampleImage(100, 100, 30)
+	misses := 0
+	total := 0.0
+	for seed := int64(0); seed < 20; seed++ {
+		oct, err := NewOctree(3)
+		c.Assert(err, check.IsNil)
+		s, err := NewSampler(oct, 0.1, 10, seed)
+		c.Assert(err, check.IsNil)
+		s.AddImage(img)
+		c.Check(s.Pixels(), check.Equals, uint64(10000))
+		// Every tile holds 100 pixels, so exactly 10 of each are sampled
+		c.Check(s.Sampled(), check.Equals, uint64(1000))
+		estimates, err := s.Estimates(1, 0.95)
+		c.Assert(err, check.IsNil)
+		c.Assert(estimates, check.HasLen, 1)
+		e := estimates[0]
+		c.Check([3]uint8{e.R, e.G, e.B}, check.Equals, [3]uint8{0, 0, 0xFF})
+		c.Check(e.Count, check.Equals, float64(e.Sampled)*10)
+		c.Check(e.Low < e.Count && e.Count < e.High, check.Equals, true)
+		if e.Low > 7000 || e.High < 7000 {
+			misses++
+		}
+		total += e.Count
+	}
+	// The bounds are wide, and the estimates average out to the truth
+	c.Check(misses <= 1, check.Equals, true)
+	c.Check(total/20 > 6900 && total/20 < 7100, check.Equals, true, check.Commentf("%v", total/20))
+}
+
+func (*SampleSuite) TestSampleSeed(c *check.C) {
+	img := sampleImage(37, 23, 11)
+	var trees []*Octree
+	for _, seed := range []int64{5, 5, 6} {
+		oct, err := NewOctree(3)
+		c.Assert(err, check.IsNil)
+		s, err := NewSampler(oct, 0.3, 8, seed)
+		c.Assert(err, check.IsNil)
+		s.AddImage(img)
+		trees = append(trees, oct)
+	}
+	c.Check(trees[0].sortedValues(), check.DeepEquals, trees[1].sortedValues())
+	c.Check(trees[0].sortedValues(), check.Not(check.DeepEquals), trees[2].sortedValues())
+}
+
+func (*SampleSuite) TestPixelRGB(c *check.C) {
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	img.Set(0, 0, color.NRGBA{R: 0x10, G: 0x20, B: 0x30, A: 0xFF})
+	img.Set(1, 0, color.NRGBA{R: 0xFF, G: 0x80, B: 0x00, A: 0x80})
+	r, g, b, ok := PixelRGB(img, 0, 0)
+	c.Check([]any{r, g, b, ok}, check.DeepEquals, []any{uint8(0x10), uint8(0x20), uint8(0x30), true})
+	// The color is kept, even though it is half transparent
+	r, g, b, ok = PixelRGB(img, 1, 0)
+	c.Check([]any{r, g, b, ok}, check.DeepEquals, []any{uint8(0xFF), uint8(0x80), uint8(0x00), true})
+	_, _, _, ok = PixelRGB(img, 2, 0)
+	c.Check(ok, check.Equals, false)
+}
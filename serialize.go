@@ -0,0 +1,235 @@
+package octree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"slices"
+)
+
+// The binary format written by MarshalBinary. All integers are little-endian.
+//
+//	offset  size  field
+//	0       4     magic "OCTR"
+//	4       2     format version (currently 1)
+//	6       1     depth
+//	7       1     ordering (0 = Morton, 1 = Hilbert)
+//	8       1     storage (1 = dense, 2 = sparse)
+//	9       1     flags (1 = 64 bit counts, 2 = node stats)
+//	10      2     reserved, must be 0
+//	12      4     max leaf size (0 unless the tree has adaptive leaves)
+//	16      8     total count
+//	24      4     number of entries (N)
+//	28      11*N  entries, each r, g, b (1 byte each), then count (8 bytes)
+//	28+11N  4     CRC-32 (IEEE) of every byte before it
+//
+// Only colors that have been added are stored, sorted by their Morton index
+// (interleaveRGB), regardless of the ordering of the tree. Each count must be
+// non-zero, and together they must add up to the total count. The layer
+// counts are not stored, they are rebuilt when the tree is loaded. Counts are
+// written as 64 bits, so that the format doesn't have to change for trees
+// that count more than a uint32 can hold. Without the 64 bit counts flag, the
+// total count must fit in a uint32.
+//
+// The flags record WithCounts64 and WithNodeStats, whose totals are rebuilt
+// along with the layer counts. WithDecay and WithWindow are not stored: a
+// tree loaded from a decaying tree or one with a window has the same counts,
+// but they no longer decay or fall out of a window when it Ticks.
+const (
+	binaryMagic      = "OCTR"
+	binaryVersion    = 1
+	binaryHeaderSize = 28
+	binaryEntrySize  = 11
+	binaryCRCSize    = 4
+	// The flags set for trees built WithCounts64 and WithNodeStats
+	binaryFlagCounts64   = 1
+	binaryFlagNodeStats  = 2
+	binaryFlagsSupported = binaryFlagCounts64 | binaryFlagNodeStats
+	// There can't be more distinct colors than this
+	binaryMaxEntries = 1 << 24
+)
+
+// The values of the tree, sorted by Morton index.
+func (o *Octree) sortedValues() []*value {
+	var values []*value
+	o.eachLeaf(o.rootCursor(), func(leaf []*value) {
+		values = append(values, leaf...)
+	})
+	slices.SortFunc(values, func(x, y *value) int {
+		return int(interleaveRGB(x.r, x.g, x.b)) - int(interleaveRGB(y.r, y.g, y.b))
+	})
+	return values
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, using the format
+// described above binaryMagic.
+func (o *Octree) MarshalBinary() ([]byte, error) {
+	values := o.sortedValues()
+	data := make([]byte, binaryHeaderSize, binaryHeaderSize+
+		len(values)*binaryEntrySize+binaryCRCSize)
+	copy(data, binaryMagic)
+	binary.LittleEndian.PutUint16(data[4:], binaryVersion)
+	data[6] = uint8(o.depth)
+	data[7] = uint8(o.ordering)
+	data[8] = uint8(DenseStorage)
+	if o.root != nil {
+		data[8] = uint8(SparseStorage)
+	}
+	if o.maxCount > math.MaxUint32 {
+		data[9] |= binaryFlagCounts64
+	}
+	if o.layerTotals != nil || o.nodeStats {
+		data[9] |= binaryFlagNodeStats
+	}
+	binary.LittleEndian.PutUint32(data[12:], uint32(o.maxLeafSize))
+	binary.LittleEndian.PutUint64(data[16:], uint64(o.count))
+	binary.LittleEndian.PutUint32(data[24:], uint32(len(values)))
+	for _, v := range values {
+		data = append(data, v.r, v.g, v.b)
+		data = binary.LittleEndian.AppendUint64(data, uint64(v.count))
+	}
+	data = binary.LittleEndian.AppendUint32(data, crc32.ChecksumIEEE(data))
+	return data, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. It replaces the
+// contents of the tree with the contents of data, including its depth and
+// ordering.
+func (o *Octree) UnmarshalBinary(data []byte) error {
+	if len(data) < binaryHeaderSize {
+		return fmt.Errorf("Truncated octree data: %d bytes is too short for the header",
+			len(data))
+	}
+	if string(data[:4]) != binaryMagic {
+		return fmt.Errorf("Invalid octree data: bad magic %q", data[:4])
+	}
+	if version := binary.LittleEndian.Uint16(data[4:]); version != binaryVersion {
+		return fmt.Errorf("Unsupported octree data version: %d", version)
+	}
+	entries := binary.LittleEndian.Uint32(data[24:])
+	if entries > binaryMaxEntries {
+		return fmt.Errorf("Invalid octree data: %d entries is more than there are colors",
+			entries)
+	}
+	size := binaryHeaderSize + int(entries)*binaryEntrySize + binaryCRCSize
+	if len(data) < size {
+		return fmt.Errorf("Truncated octree data: %d entries needs %d bytes, only have %d",
+			entries, size, len(data))
+	}
+	if len(data) > size {
+		return fmt.Errorf("Invalid octree data: %d unexpected bytes after the checksum",
+			len(data)-size)
+	}
+	body := data[:size-binaryCRCSize]
+	expected := binary.LittleEndian.Uint32(data[size-binaryCRCSize:])
+	if actual := crc32.ChecksumIEEE(body); actual != expected {
+		return fmt.Errorf("Octree data checksum mismatch: expected %08x, got %08x",
+			expected, actual)
+	}
+	depth := int(data[6])
+	ordering := Ordering(data[7])
+	storage := Storage(data[8])
+	if storage != DenseStorage && storage != SparseStorage {
+		return fmt.Errorf("Invalid octree data: unknown storage %d", storage)
+	}
+	flags := data[9]
+	if flags&^binaryFlagsSupported != 0 {
+		return fmt.Errorf("Invalid octree data: unknown flags %#x", flags)
+	}
+	if data[10] != 0 || data[11] != 0 {
+		return fmt.Errorf("Invalid octree data: reserved bytes are not 0")
+	}
+	maxLeafSize := binary.LittleEndian.Uint32(data[12:])
+	total := binary.LittleEndian.Uint64(data[16:])
+	if flags&binaryFlagCounts64 == 0 && total > math.MaxUint32 {
+		return fmt.Errorf("Invalid octree data: total count %d is too large", total)
+	}
+	if maxLeafSize > math.MaxInt32 {
+		return fmt.Errorf("Invalid octree data: max leaf size %d is too large", maxLeafSize)
+	}
+	options := []Option{WithOrdering(ordering), WithStorage(storage),
+		WithAdaptiveLeaves(int(maxLeafSize))}
+	if flags&binaryFlagCounts64 != 0 {
+		options = append(options, WithCounts64())
+	}
+	if flags&binaryFlagNodeStats != 0 {
+		options = append(options, WithNodeStats())
+	}
+	tree, err := NewOctree(depth, options...)
+	if err != nil {
+		return fmt.Errorf("Invalid octree data: %v", err)
+	}
+	sum := uint64(0)
+	lastIndex := uint32(0)
+	for i := 0; i < int(entries); i++ {
+		entry := body[binaryHeaderSize+i*binaryEntrySize:]
+		r, g, b := entry[0], entry[1], entry[2]
+		count := binary.LittleEndian.Uint64(entry[3:])
+		index := interleaveRGB(r, g, b)
+		if i > 0 && index <= lastIndex {
+			return fmt.Errorf("Invalid octree data: entry %d (#%02x%02x%02x) is out of order",
+				i, r, g, b)
+		}
+		lastIndex = index
+		if count == 0 {
+			return fmt.Errorf("Invalid octree data: entry %d (#%02x%02x%02x) has a count of 0",
+				i, r, g, b)
+		}
+		// Checked before adding, so that sum can't wrap
+		if count > total-sum {
+			return fmt.Errorf("Invalid octree data: entries add up to more than the total count %d",
+				total)
+		}
+		sum += count
+		tree.addCount(r, g, b, count)
+	}
+	if sum != total {
+		return fmt.Errorf("Invalid octree data: entries add up to %d, not the total count %d",
+			sum, total)
+	}
+	*o = *tree
+	return nil
+}
+
+// WriteTo implements io.WriterTo, writing the same format as MarshalBinary.
+func (o *Octree) WriteTo(w io.Writer) (int64, error) {
+	data, err := o.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+// ReadFrom implements io.ReaderFrom, reading exactly one tree written by
+// WriteTo, and replacing the contents of the tree with it.
+func (o *Octree) ReadFrom(r io.Reader) (int64, error) {
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, r, binaryHeaderSize)
+	if err != nil {
+		return n, readError(err, buf.Len())
+	}
+	entries := binary.LittleEndian.Uint32(buf.Bytes()[24:])
+	if string(buf.Bytes()[:4]) != binaryMagic || entries > binaryMaxEntries {
+		// Let UnmarshalBinary explain what is wrong with the header
+		return n, o.UnmarshalBinary(buf.Bytes())
+	}
+	rest := int64(entries)*binaryEntrySize + binaryCRCSize
+	m, err := io.CopyN(&buf, r, rest)
+	n += m
+	if err != nil {
+		return n, readError(err, buf.Len())
+	}
+	return n, o.UnmarshalBinary(buf.Bytes())
+}
+
+func readError(err error, read int) error {
+	if err == io.EOF {
+		return fmt.Errorf("Truncated octree data: unexpected end of input after %d bytes",
+			read)
+	}
+	return err
+}
@@ -0,0 +1,244 @@
+package octree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"math"
+	"math/rand"
+
+	"gopkg.in/check.v1"
+)
+
+type SerializeSuite struct{}
+
+var _ = check.Suite(&SerializeSuite{})
+
+func (*SerializeSuite) TestMarshalEmpty(c *check.C) {
+	oct, err := NewOctree(3)
+	c.Assert(err, check.IsNil)
+	data, err := oct.MarshalBinary()
+	c.Assert(err, check.IsNil)
+	c.Check(data[:28], check.DeepEquals, []byte{
+		'O', 'C', 'T', 'R', // magic
+		1, 0, // version
+		3,    // depth
+		0,    // ordering
+		1,    // storage
+		0,    // flags
+		0, 0, // reserved
+		0, 0, 0, 0, // max leaf size
+		0, 0, 0, 0, 0, 0, 0, 0, // total count
+		0, 0, 0, 0, // entries
+	})
+	c.Check(data, check.HasLen, 32)
+	c.Check(binary.LittleEndian.Uint32(data[28:]), check.Equals,
+		crc32.ChecksumIEEE(data[:28]))
+}
+
+func (*SerializeSuite) TestMarshalEntries(c *check.C) {
+	oct, err := NewOctree(2)
+	c.Assert(err, check.IsNil)
+	oct.Add(0xFF, 0x00, 0x00)
+	oct.Add(0x01, 0x02, 0x03)
+	oct.Add(0xFF, 0x00, 0x00)
+	data, err := oct.MarshalBinary()
+	c.Assert(err, check.IsNil)
+	c.Check(data[16:28], check.DeepEquals, []byte{
+		3, 0, 0, 0, 0, 0, 0, 0, // total count
+		2, 0, 0, 0, // entries
+	})
+	// Entries are in morton order
+	c.Check(data[28:50], check.DeepEquals, []byte{
+		0x01, 0x02, 0x03, 1, 0, 0, 0, 0, 0, 0, 0,
+		0xFF, 0x00, 0x00, 2, 0, 0, 0, 0, 0, 0, 0,
+	})
+	c.Check(data, check.HasLen, 54)
+}
+
+func checkRoundTrip(c *check.C, oct *Octree) *Octree {
+	data, err := oct.MarshalBinary()
+	c.Assert(err, check.IsNil)
+	loaded := &Octree{}
+	c.Assert(loaded.UnmarshalBinary(data), check.IsNil)
+	c.Check(loaded.depth, check.Equals, oct.depth)
+	c.Check(loaded.ordering, check.Equals, oct.ordering)
+	c.Check(loaded.maxLeafSize, check.Equals, oct.maxLeafSize)
+	c.Check(loaded.count, check.Equals, oct.count)
+	c.Check(loaded.layerCounts, check.DeepEquals, oct.layerCounts)
+	c.Check(loaded.layerCounts64, check.DeepEquals, oct.layerCounts64)
+	c.Check(loaded.maxCount, check.Equals, oct.maxCount)
+	c.Check(loaded.sortedValues(), check.DeepEquals, oct.sortedValues())
+	return loaded
+}
+
+func (*SerializeSuite) TestRoundTrip(c *check.C) {
+	rng := rand.New(rand.NewSource(1))
+	for _, options := range [][]Option{
+		nil,
+		{WithOrdering(HilbertOrder)},
+		{WithStorage(SparseStorage)},
+		{WithAdaptiveLeaves(4)},
+		{WithCounts64()},
+		{WithNodeStats()},
+		{WithNodeStats(), WithStorage(SparseStorage)},
+	} {
+		oct, err := NewOctree(4, options...)
+		c.Assert(err, check.IsNil)
+		checkRoundTrip(c, oct)
+		for i := 0; i < 500; i++ {
+			oct.Add(uint8(rng.Intn(64)), uint8(rng.Intn(256)), uint8(rng.Intn(256)))
+		}
+		loaded := checkRoundTrip(c, oct)
+		c.Check(loaded.root != nil, check.Equals, oct.root != nil)
+		c.Check(loaded.FindClosest(1, 2, 3), check.DeepEquals, oct.FindClosest(1, 2, 3))
+		c.Check(loaded.layerTotals, check.DeepEquals, oct.layerTotals)
+		c.Check(loaded.nodeStats, check.Equals, oct.nodeStats)
+		stats, ok := loaded.NodeStats(Node{})
+		c.Check(ok, check.Equals, true)
+		expected, _ := oct.NodeStats(Node{})
+		c.Check(stats, check.DeepEquals, expected)
+	}
+}
+
+// Decay and windows aren't stored, so the loaded tree keeps the counts but
+// not how they change.
+func (*SerializeSuite) TestRoundTripTime(c *check.C) {
+	for _, option := range []Option{WithDecay(0.5), WithWindow(2)} {
+		oct, err := NewOctree(3, option)
+		c.Assert(err, check.IsNil)
+		oct.Add(1, 2, 3)
+		loaded := checkRoundTrip(c, oct)
+		c.Check(loaded.decay, check.Equals, 0.0)
+		c.Check(loaded.window, check.IsNil)
+		c.Check(loaded.count, check.Equals, oct.count)
+		loaded.Tick()
+		c.Check(loaded.count, check.Equals, oct.count)
+	}
+}
+
+func (*SerializeSuite) TestWriteToReadFrom(c *check.C) {
+	oct, err := NewOctree(3)
+	c.Assert(err, check.IsNil)
+	oct.Add(1, 2, 3)
+	oct.Add(4, 5, 6)
+	var buf bytes.Buffer
+	n, err := oct.WriteTo(&buf)
+	c.Assert(err, check.IsNil)
+	c.Check(n, check.Equals, int64(54))
+	// Anything after the tree is left alone
+	buf.WriteString("trailing")
+	loaded := &Octree{}
+	n, err = loaded.ReadFrom(&buf)
+	c.Assert(err, check.IsNil)
+	c.Check(n, check.Equals, int64(54))
+	c.Check(loaded.sortedValues(), check.DeepEquals, oct.sortedValues())
+	c.Check(buf.String(), check.Equals, "trailing")
+}
+
+// Fix up the checksum after corrupting the data, so that we test the other
+// checks.
+func resum(data []byte) []byte {
+	body := data[:len(data)-4]
+	binary.LittleEndian.PutUint32(data[len(body):], crc32.ChecksumIEEE(body))
+	return data
+}
+
+func (*SerializeSuite) TestUnmarshalErrors(c *check.C) {
+	oct, err := NewOctree(3)
+	c.Assert(err, check.IsNil)
+	oct.Add(1, 2, 3)
+	oct.Add(4, 5, 6)
+	good, err := oct.MarshalBinary()
+	c.Assert(err, check.IsNil)
+	corrupt := func(f func(data []byte) []byte) []byte {
+		return f(append([]byte(nil), good...))
+	}
+	for _, test := range []struct {
+		data []byte
+		err  string
+	}{{
+		data: good[:10],
+		err:  "Truncated octree data: 10 bytes is too short for the header",
+	}, {
+		data: good[:40],
+		err:  "Truncated octree data: 2 entries needs 54 bytes, only have 40",
+	}, {
+		data: append(append([]byte(nil), good...), 0),
+		err:  "Invalid octree data: 1 unexpected bytes after the checksum",
+	}, {
+		data: corrupt(func(d []byte) []byte { d[0] = 'X'; return d }),
+		err:  `Invalid octree data: bad magic "XCTR"`,
+	}, {
+		data: corrupt(func(d []byte) []byte { d[4] = 2; return d }),
+		err:  "Unsupported octree data version: 2",
+	}, {
+		data: corrupt(func(d []byte) []byte { d[30]++; return d }),
+		err:  "Octree data checksum mismatch: expected .*, got .*",
+	}, {
+		data: corrupt(func(d []byte) []byte { d[6] = 12; return resum(d) }),
+		err:  "Invalid octree data: Invalid octree depth: 12",
+	}, {
+		data: corrupt(func(d []byte) []byte { d[7] = 9; return resum(d) }),
+		err:  "Invalid octree data: Invalid octree ordering: 9",
+	}, {
+		data: corrupt(func(d []byte) []byte { d[8] = 0; return resum(d) }),
+		err:  "Invalid octree data: unknown storage 0",
+	}, {
+		data: corrupt(func(d []byte) []byte { d[9] = 5; return resum(d) }),
+		err:  "Invalid octree data: unknown flags 0x5",
+	}, {
+		data: corrupt(func(d []byte) []byte { d[10] = 1; return resum(d) }),
+		err:  "Invalid octree data: reserved bytes are not 0",
+	}, {
+		data: corrupt(func(d []byte) []byte { d[16] = 3; return resum(d) }),
+		err:  "Invalid octree data: entries add up to 2, not the total count 3",
+	}, {
+		data: corrupt(func(d []byte) []byte { d[16] = 1; return resum(d) }),
+		err:  "Invalid octree data: entries add up to more than the total count 1",
+	}, {
+		// Counts that would wrap around if they were added up first
+		data: corrupt(func(d []byte) []byte {
+			d[9] = binaryFlagCounts64
+			binary.LittleEndian.PutUint64(d[16:], math.MaxUint64)
+			binary.LittleEndian.PutUint64(d[31:], math.MaxUint64)
+			binary.LittleEndian.PutUint64(d[42:], math.MaxUint64)
+			return resum(d)
+		}),
+		err: "Invalid octree data: entries add up to more than the total count 18446744073709551615",
+	}, {
+		data: corrupt(func(d []byte) []byte { d[42] = 0; return resum(d) }),
+		err:  `Invalid octree data: entry 1 \(#040506\) has a count of 0`,
+	}, {
+		data: corrupt(func(d []byte) []byte {
+			copy(d[39:42], []byte{1, 2, 3})
+			return resum(d)
+		}),
+		err: `Invalid octree data: entry 1 \(#010203\) is out of order`,
+	}, {
+		data: corrupt(func(d []byte) []byte { d[27] = 0xFF; return d }),
+		err:  "Invalid octree data: 4278190082 entries is more than there are colors",
+	}} {
+		loaded := &Octree{}
+		c.Check(loaded.UnmarshalBinary(test.data), check.ErrorMatches, test.err)
+		// Nothing was loaded
+		c.Check(loaded, check.DeepEquals, &Octree{})
+	}
+}
+
+func (*SerializeSuite) TestReadFromTruncated(c *check.C) {
+	oct, err := NewOctree(3)
+	c.Assert(err, check.IsNil)
+	oct.Add(1, 2, 3)
+	data, err := oct.MarshalBinary()
+	c.Assert(err, check.IsNil)
+	loaded := &Octree{}
+	_, err = loaded.ReadFrom(bytes.NewReader(data[:30]))
+	c.Check(err, check.ErrorMatches,
+		"Truncated octree data: unexpected end of input after 30 bytes")
+	_, err = loaded.ReadFrom(bytes.NewReader(data[:3]))
+	c.Check(err, check.ErrorMatches,
+		"Truncated octree data: unexpected end of input after 3 bytes")
+	_, err = loaded.ReadFrom(bytes.NewReader([]byte("not an octree, just some other bytes")))
+	c.Check(err, check.ErrorMatches, `Invalid octree data: bad magic "not "`)
+}
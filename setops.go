@@ -0,0 +1,205 @@
+package octree
+
+import (
+	"fmt"
+)
+
+// Subtract takes the counts of other away from the counts of the same colors
+// in o, dropping any colors that reach 0, such as to remove a background.
+// Colors that other has more of than o are dropped, rather than going below
+// 0. Blocks that other has nothing in are skipped without being walked.
+func (o *Octree) Subtract(other *Octree) error {
+	return o.combineCounts(other, true, func(count, otherCount uint64) uint64 {
+		if otherCount >= count {
+			return 0
+		}
+		return count - otherCount
+	})
+}
+
+// Intersect keeps only the colors that are in both o and other, each with the
+// smaller of its two counts. Blocks that other has nothing in are emptied
+// without looking up any of their colors.
+func (o *Octree) Intersect(other *Octree) error {
+	return o.combineCounts(other, false, func(count, otherCount uint64) uint64 {
+		return min(count, otherCount)
+	})
+}
+
+// Walk the blocks of o, setting the count of every color to apply(count,
+// count in other), and fixing up the counts of the blocks as we come back
+// up. If skipUnmatched, blocks that other has nothing in are left alone,
+// otherwise every color in them gets apply(count, 0).
+func (o *Octree) combineCounts(other *Octree, skipUnmatched bool,
+	apply func(count, otherCount uint64) uint64) error {
+	if o.depth != other.depth {
+		return fmt.Errorf("Octrees have different depths: %d and %d", o.depth, other.depth)
+	}
+	if o.window != nil {
+		return fmt.Errorf("Can't change the counts of an octree with a window")
+	}
+	w := countsWalk{o: o, other: other, skipUnmatched: skipUnmatched, apply: apply}
+	w.walk(o.rootCursor(), false)
+	if o.layerTotals != nil || o.nodeStats {
+		o.rebuildTotals()
+	}
+	return nil
+}
+
+type countsWalk struct {
+	o, other      *Octree
+	skipUnmatched bool
+	apply         func(count, otherCount uint64) uint64
+}
+
+// Apply the walk to the block at c, returning how much its count dropped by.
+// unmatched is set once a block that other has nothing in has been reached.
+func (w *countsWalk) walk(c cursor, unmatched bool) uint64 {
+	o := w.o
+	if !unmatched && w.other.blockCount(o, c) == 0 {
+		if w.skipUnmatched {
+			return 0
+		}
+		unmatched = true
+	}
+	removed := uint64(0)
+	if o.cursorIsLeaf(c) {
+		values := o.cursorValues(c)
+		kept := values[:0]
+		for _, v := range values {
+			otherCount := uint64(0)
+			if !unmatched {
+				otherCount = w.other.colorCount(v.r, v.g, v.b)
+			}
+			count := w.apply(v.count, otherCount)
+			removed += v.count - count
+			v.count = count
+			if count > 0 {
+				kept = append(kept, v)
+			}
+		}
+		clear(values[len(kept):])
+		if len(kept) == 0 {
+			kept = nil
+		}
+		o.setCursorValues(c, kept)
+	} else {
+		var buf [8]cursor
+		for _, child := range o.appendChildren(buf[:0], c) {
+			removed += w.walk(child, unmatched)
+		}
+	}
+	o.uncountCursor(c, removed)
+	if c.n != nil && c.n.children != nil {
+		for i, child := range c.n.children {
+			if child != nil && child.count == 0 {
+				c.n.children[i] = nil
+			}
+		}
+		if o.maxLeafSize > 0 {
+			o.mergeChildren(c.n)
+		}
+	}
+	return removed
+}
+
+// The count of o in the block that is at c in tree, which may have a
+// different ordering.
+func (o *Octree) blockCount(tree *Octree, c cursor) uint64 {
+	index := c.index
+	if tree.ordering != o.ordering {
+		r, g, b := tree.blockCoords(c.index, c.level)
+		index = o.blockIndex(r, g, b, c.level)
+	}
+	return o.countAt(c.level, index)
+}
+
+// The count of the block at level with the given index.
+func (o *Octree) countAt(level uint, index uint32) uint64 {
+	found, ok := o.findCursor(level, index)
+	if !ok {
+		return 0
+	}
+	if found.level == level {
+		return o.cursorCount(found)
+	}
+	// An adaptive leaf covering more than the block
+	count := uint64(0)
+	for v := range o.NodeEntries(Node{Level: int(level), Index: index}) {
+		count += v.count
+	}
+	return count
+}
+
+// How many times r,g,b has been counted.
+func (o *Octree) colorCount(r, g, b uint8) uint64 {
+	index := o.key(r, g, b)
+	var values []*value
+	if o.root != nil {
+		n := o.root
+		for level := uint(1); n.children != nil; level++ {
+			n = n.children[childSlot(index, level)]
+			if n == nil {
+				return 0
+			}
+		}
+		values = n.values
+	} else {
+		values = o.values[index>>uint(24-o.denseLayers()*3)]
+	}
+	for _, v := range values {
+		if v.r == r && v.g == g && v.b == b {
+			return v.count
+		}
+	}
+	return 0
+}
+
+func (o *Octree) setCursorValues(c cursor, values []*value) {
+	if c.n != nil {
+		c.n.values = values
+		return
+	}
+	o.values[c.index] = values
+}
+
+// Take n off the count of the block at c.
+func (o *Octree) uncountCursor(c cursor, n uint64) {
+	switch {
+	case c.n != nil:
+		c.n.count -= n
+	case c.level == 0:
+		// The root of a dense tree is only counted by o.count
+	case o.layerCounts64 != nil:
+		o.layerCounts64[c.level-1][c.index] -= n
+	default:
+		o.layerCounts[c.level-1][c.index] -= uint32(n)
+	}
+	if c.level == 0 {
+		o.count -= n
+	}
+}
+
+// Work out the totals of every block again from the colors.
+func (o *Octree) rebuildTotals() {
+	for _, totals := range o.layerTotals {
+		clear(totals)
+	}
+	var rebuild func(c cursor) *nodeTotals
+	rebuild = func(c cursor) *nodeTotals {
+		t := o.cursorTotals(c)
+		*t = nodeTotals{}
+		if o.cursorIsLeaf(c) {
+			for _, v := range o.cursorValues(c) {
+				t.add(v.r, v.g, v.b, v.count)
+			}
+			return t
+		}
+		var buf [8]cursor
+		for _, child := range o.appendChildren(buf[:0], c) {
+			t.combine(rebuild(child))
+		}
+		return t
+	}
+	rebuild(o.rootCursor())
+}
@@ -0,0 +1,123 @@
+package octree
+
+import (
+	"math/rand"
+
+	"gopkg.in/check.v1"
+)
+
+type SetOpsSuite struct{}
+
+var _ = check.Suite(&SetOpsSuite{})
+
+var setOpsOptions = [][]Option{
+	nil,
+	{WithOrdering(HilbertOrder)},
+	{WithStorage(SparseStorage)},
+	{WithAdaptiveLeaves(3)},
+	{WithNodeStats()},
+	{WithStorage(SparseStorage), WithNodeStats(), WithCounts64()},
+}
+
+// Random colors, all with blue below 0x80, so that half of the blocks at
+// level 1 are empty.
+func setOpsColors(rng *rand.Rand, n int) map[[3]uint8]uint64 {
+	counts := make(map[[3]uint8]uint64)
+	for i := 0; i < n; i++ {
+		counts[[3]uint8{uint8(rng.Intn(4) * 64), uint8(rng.Intn(4) * 64), uint8(rng.Intn(128))}]++
+	}
+	return counts
+}
+
+func setOpsTree(c *check.C, counts map[[3]uint8]uint64, options ...Option) *Octree {
+	oct, err := NewOctree(3, options...)
+	c.Assert(err, check.IsNil)
+	for rgb, count := range counts {
+		oct.addCount(rgb[0], rgb[1], rgb[2], count)
+	}
+	return oct
+}
+
+func checkSetOp(c *check.C, oct *Octree, expected map[[3]uint8]uint64) {
+	actual := make(map[[3]uint8]uint64)
+	for v := range oct.Entries() {
+		actual[[3]uint8{v.r, v.g, v.b}] = v.count
+	}
+	c.Check(actual, check.DeepEquals, expected)
+	checkLayersAgree(c, oct)
+	if oct.root != nil {
+		checkLeafSizes(c, oct.root, max(oct.maxLeafSize, 1<<24))
+	}
+	if oct.layerTotals == nil && !oct.nodeStats {
+		return
+	}
+	// The totals have to match walking the colors
+	for level := 0; level < oct.depth; level++ {
+		for n := range oct.Nodes(level) {
+			stats, ok := oct.NodeStats(n)
+			c.Assert(ok, check.Equals, true)
+			var walked nodeTotals
+			for v := range oct.NodeEntries(n) {
+				walked.add(v.r, v.g, v.b, v.count)
+			}
+			c.Check(stats, check.DeepEquals, walked.stats())
+		}
+	}
+}
+
+func (*SetOpsSuite) TestSubtractAndIntersect(c *check.C) {
+	rng := rand.New(rand.NewSource(1))
+	aCounts := setOpsColors(rng, 300)
+	bCounts := setOpsColors(rng, 300)
+	// Some colors that only b has, in blocks that a has nothing in
+	bCounts[[3]uint8{0xFF, 0xFF, 0xFF}] = 3
+	difference := make(map[[3]uint8]uint64)
+	intersection := make(map[[3]uint8]uint64)
+	for rgb, count := range aCounts {
+		if count > bCounts[rgb] {
+			difference[rgb] = count - bCounts[rgb]
+		}
+		if n := min(count, bCounts[rgb]); n > 0 {
+			intersection[rgb] = n
+		}
+	}
+	for _, options := range setOpsOptions {
+		for _, otherOptions := range setOpsOptions[:4] {
+			b := setOpsTree(c, bCounts, otherOptions...)
+			a := setOpsTree(c, aCounts, options...)
+			c.Assert(a.Subtract(b), check.IsNil)
+			checkSetOp(c, a, difference)
+			a = setOpsTree(c, aCounts, options...)
+			c.Assert(a.Intersect(b), check.IsNil)
+			checkSetOp(c, a, intersection)
+			// b is left alone
+			checkSetOp(c, b, bCounts)
+		}
+	}
+}
+
+func (*SetOpsSuite) TestSubtractEverything(c *check.C) {
+	for _, options := range setOpsOptions {
+		counts := map[[3]uint8]uint64{{1, 2, 3}: 2, {0xFF, 0, 0}: 1}
+		a := setOpsTree(c, counts, options...)
+		c.Assert(a.Subtract(setOpsTree(c, counts)), check.IsNil)
+		checkSetOp(c, a, map[[3]uint8]uint64{})
+		c.Check(a.count, check.Equals, uint64(0))
+		a.Add(1, 2, 3)
+		checkSetOp(c, a, map[[3]uint8]uint64{{1, 2, 3}: 1})
+		c.Assert(a.Intersect(setOpsTree(c, nil)), check.IsNil)
+		checkSetOp(c, a, map[[3]uint8]uint64{})
+	}
+}
+
+func (*SetOpsSuite) TestSetOpsErrors(c *check.C) {
+	a := setOpsTree(c, nil)
+	deeper, err := NewOctree(4)
+	c.Assert(err, check.IsNil)
+	c.Check(a.Subtract(deeper), check.ErrorMatches, "Octrees have different depths: 3 and 4")
+	c.Check(a.Intersect(deeper), check.ErrorMatches, "Octrees have different depths: 3 and 4")
+	windowed, err := NewOctree(3, WithWindow(2))
+	c.Assert(err, check.IsNil)
+	c.Check(windowed.Subtract(a), check.ErrorMatches,
+		"Can't change the counts of an octree with a window")
+}
@@ -0,0 +1,303 @@
+package octree
+
+import (
+	"slices"
+)
+
+// A block of a sparse tree. Only the children that have been populated are
+// allocated.
+type node struct {
+	count uint64
+	// children is nil for leaves
+	children *[8]*node
+	// Only leaves have values
+	values []*value
+	// Only set when the tree keeps node stats
+	totals *nodeTotals
+}
+
+func newSparseOctree(depth int, opts octreeOptions) *Octree {
+	o := &Octree{
+		depth:       depth,
+		maxLeafSize: opts.maxLeafSize,
+		ordering:    opts.ordering,
+		nodeStats:   opts.nodeStats,
+		maxCount:    opts.maxCount(),
+	}
+	o.root = o.newNode()
+	o.setTimeOptions(opts)
+	return o
+}
+
+// Allocate an empty node, with totals if the tree keeps them.
+func (o *Octree) newNode() *node {
+	if o.nodeStats {
+		return &node{totals: &nodeTotals{}}
+	}
+	return &node{}
+}
+
+func (o *Octree) addSparse(r, g, b uint8, index uint32, count uint64) {
+	n := o.root
+	n.count += count
+	n.addTotals(r, g, b, count)
+	level := uint(0)
+	for ; level < uint(o.depth-1); level++ {
+		if n.children == nil {
+			if o.maxLeafSize > 0 {
+				// Adaptive leaves can be at any level
+				break
+			}
+			n.children = new([8]*node)
+		}
+		child := childSlot(index, level+1)
+		if n.children[child] == nil {
+			n.children[child] = o.newNode()
+		}
+		n = n.children[child]
+		n.count += count
+		n.addTotals(r, g, b, count)
+	}
+	n.values = addValue(n.values, r, g, b, count)
+	if o.maxLeafSize > 0 && len(n.values) > o.maxLeafSize {
+		o.splitLeaf(n, level)
+	}
+}
+
+// Which of the 8 children of its parent holds index at the given level.
+func childSlot(index uint32, level uint) uint32 {
+	return index >> (24 - level*3) & 0x7
+}
+
+func (o *Octree) removeSparse(r, g, b uint8, index uint32, count uint64) bool {
+	// Find the leaf before changing anything, in case r,g,b isn't there
+	path := []*node{o.root}
+	n := o.root
+	for level := uint(1); n.children != nil; level++ {
+		n = n.children[childSlot(index, level)]
+		if n == nil {
+			return false
+		}
+		path = append(path, n)
+	}
+	valueSlice, found := removeValue(n.values, r, g, b, count)
+	if !found {
+		return false
+	}
+	gone := len(valueSlice) < len(n.values)
+	n.values = valueSlice
+	o.count -= count
+	for _, n := range path {
+		n.count -= count
+		if n.totals != nil {
+			n.totals.remove(r, g, b, count)
+		}
+	}
+	// Drop the nodes that are now empty
+	for level := len(path) - 1; level > 0; level-- {
+		if path[level].count == 0 {
+			path[level-1].children[childSlot(index, uint(level))] = nil
+		}
+	}
+	if gone && o.nodeStats {
+		o.refitSparseTotals(path)
+	}
+	if o.maxLeafSize > 0 {
+		// The parent of the leaf is the deepest node that could merge
+		for level := len(path) - 2; level >= 0; level-- {
+			if !o.mergeChildren(path[level]) {
+				break
+			}
+		}
+	}
+	return true
+}
+
+// A cursor points at one block of the tree, so that dense and sparse trees can
+// be walked the same way.
+type cursor struct {
+	// The root is level 0, and the leaves of a dense tree are at depth-1
+	level uint
+	// The index of the block within its level
+	index uint32
+	// The node for the block in a sparse tree, nil for a dense tree
+	n *node
+}
+
+func (o *Octree) rootCursor() cursor {
+	return cursor{n: o.root}
+}
+
+// The number of values counted in the block.
+func (o *Octree) cursorCount(c cursor) uint64 {
+	if c.n != nil {
+		return c.n.count
+	}
+	if c.level == 0 {
+		return o.count
+	}
+	if o.layerCounts64 != nil {
+		return o.layerCounts64[c.level-1][c.index]
+	}
+	return uint64(o.layerCounts[c.level-1][c.index])
+}
+
+// Whether the block holds values rather than children.
+func (o *Octree) cursorIsLeaf(c cursor) bool {
+	if c.n != nil {
+		return c.n.children == nil
+	}
+	return int(c.level) == o.denseLayers()
+}
+
+// The values held by a leaf.
+func (o *Octree) cursorValues(c cursor) []*value {
+	if c.n != nil {
+		return c.n.values
+	}
+	return o.values[c.index]
+}
+
+// Append the children of c that hold any values, in index order.
+func (o *Octree) appendChildren(children []cursor, c cursor) []cursor {
+	if c.n != nil {
+		if c.n.children == nil {
+			return children
+		}
+		for i, child := range c.n.children {
+			if child != nil && child.count > 0 {
+				children = append(children, cursor{
+					level: c.level + 1,
+					index: c.index<<3 | uint32(i),
+					n:     child,
+				})
+			}
+		}
+		return children
+	}
+	if int(c.level) == o.denseLayers() {
+		return children
+	}
+	for i := uint32(0); i < 8; i++ {
+		child := cursor{level: c.level + 1, index: c.index<<3 | i}
+		if o.cursorCount(child) > 0 {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// The inclusive color boundary of the block.
+func (o *Octree) cursorMinMax(c cursor) (vMin, vMax value) {
+	return o.blockMinMax(c.level, c.index)
+}
+
+// The distance^2 from r,g,b to the nearest point of the inclusive box
+// [vMin, vMax]. This is 0 if r,g,b is inside the box.
+func dist2ToBox(r, g, b uint8, vMin, vMax value) uint32 {
+	d := axisDistToRange(r, vMin.r, vMax.r)
+	dist2 := d * d
+	d = axisDistToRange(g, vMin.g, vMax.g)
+	dist2 += d * d
+	d = axisDistToRange(b, vMin.b, vMax.b)
+	dist2 += d * d
+	return dist2
+}
+
+// How far v is outside of the range [vMin, vMax]
+func axisDistToRange(v, vMin, vMax uint8) uint32 {
+	if v < vMin {
+		return uint32(vMin - v)
+	}
+	if v > vMax {
+		return uint32(v - vMax)
+	}
+	return 0
+}
+
+// Find the closest value by walking down from the root, visiting the
+// children closest to r,g,b first, and skipping any that are further away
+// than the best match so far. This doesn't depend on the layout of the
+// blocks, so it works for any tree.
+func (o *Octree) findClosestByDescent(r, g, b uint8) value {
+	s := octreeSearch{o: o, r: r, g: g, b: b}
+	s.search(o.rootCursor())
+	if s.best == nil {
+		return value{}
+	}
+	return *s.best
+}
+
+type octreeSearch struct {
+	o         *Octree
+	r, g, b   uint8
+	best      *value
+	bestDist2 uint32
+}
+
+func (s *octreeSearch) search(c cursor) {
+	if s.o.cursorIsLeaf(c) {
+		for _, v := range s.o.cursorValues(c) {
+			dist2 := dist2ToV(s.r, s.g, s.b, v)
+			if s.best == nil || dist2 < s.bestDist2 {
+				s.best = v
+				s.bestDist2 = dist2
+			}
+		}
+		return
+	}
+	type child struct {
+		c     cursor
+		dist2 uint32
+	}
+	var buf [8]cursor
+	var children [8]child
+	n := 0
+	for _, cc := range s.o.appendChildren(buf[:0], c) {
+		vMin, vMax := s.o.cursorBounds(cc)
+		children[n] = child{c: cc, dist2: dist2ToBox(s.r, s.g, s.b, vMin, vMax)}
+		n++
+	}
+	sorted := children[:n]
+	slices.SortFunc(sorted, func(x, y child) int {
+		return int(x.dist2) - int(y.dist2)
+	})
+	for _, cc := range sorted {
+		if s.best != nil && cc.dist2 >= s.bestDist2 {
+			// Everything else is even further away
+			return
+		}
+		s.search(cc.c)
+	}
+}
+
+// Call f with the values of every leaf under c, in index order.
+func (o *Octree) eachLeaf(c cursor, f func(values []*value)) {
+	if o.cursorIsLeaf(c) {
+		f(o.cursorValues(c))
+		return
+	}
+	var buf [8]cursor
+	for _, child := range o.appendChildren(buf[:0], c) {
+		o.eachLeaf(child, f)
+	}
+}
+
+// Call f with the values of every leaf under c that overlaps the inclusive
+// box [vMin, vMax], in index order.
+func (o *Octree) eachLeafInBox(c cursor, vMin, vMax value, f func(values []*value)) {
+	if o.cursorIsLeaf(c) {
+		f(o.cursorValues(c))
+		return
+	}
+	var buf [8]cursor
+	for _, child := range o.appendChildren(buf[:0], c) {
+		cMin, cMax := o.cursorBounds(child)
+		if cMax.r < vMin.r || cMin.r > vMax.r ||
+			cMax.g < vMin.g || cMin.g > vMax.g ||
+			cMax.b < vMin.b || cMin.b > vMax.b {
+			continue
+		}
+		o.eachLeafInBox(child, vMin, vMax, f)
+	}
+}
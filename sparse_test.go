@@ -0,0 +1,109 @@
+package octree
+
+import (
+	"math/rand"
+
+	"gopkg.in/check.v1"
+)
+
+type SparseSuite struct{}
+
+var _ = check.Suite(&SparseSuite{})
+
+func (*SparseSuite) TestNewSparse(c *check.C) {
+	oct, err := NewOctree(3, WithStorage(SparseStorage))
+	c.Assert(err, check.IsNil)
+	c.Check(oct.layerCounts, check.IsNil)
+	c.Check(oct.values, check.IsNil)
+	c.Check(oct.root, check.DeepEquals, &node{})
+	c.Check(oct.Depth(), check.Equals, 3)
+}
+
+func (*SparseSuite) TestAutoStorage(c *check.C) {
+	oct, err := NewOctree(6)
+	c.Assert(err, check.IsNil)
+	c.Check(oct.root, check.IsNil)
+	c.Check(oct.values, check.HasLen, 32768)
+	oct, err = NewOctree(7)
+	c.Assert(err, check.IsNil)
+	c.Check(oct.root, check.NotNil)
+	c.Check(oct.values, check.IsNil)
+	// You can still ask for a dense tree
+	oct, err = NewOctree(7, WithStorage(DenseStorage))
+	c.Assert(err, check.IsNil)
+	c.Check(oct.root, check.IsNil)
+	c.Check(oct.values, check.HasLen, 262144)
+}
+
+func (*SparseSuite) TestInvalidStorage(c *check.C) {
+	oct, err := NewOctree(3, WithStorage(Storage(7)))
+	c.Check(err, check.ErrorMatches, "Invalid octree storage: 7")
+	c.Check(oct, check.IsNil)
+}
+
+func (*SparseSuite) TestAddSparse(c *check.C) {
+	oct, err := NewOctree(3, WithStorage(SparseStorage))
+	c.Assert(err, check.IsNil)
+	oct.Add(0x80, 0x80, 0x80)
+	oct.Add(0x80, 0x80, 0x80)
+	oct.Add(0xC0, 0xC0, 0xC0)
+	c.Check(oct.count, check.Equals, uint64(3))
+	c.Check(oct.root.count, check.Equals, uint64(3))
+	// Only the one top level block is allocated
+	top := oct.root.children
+	c.Assert(top, check.NotNil)
+	for i, child := range top {
+		if i != 7 {
+			c.Check(child, check.IsNil)
+		}
+	}
+	c.Check(top[7].count, check.Equals, uint64(3))
+	c.Check(top[7].children[0].children, check.IsNil)
+	c.Check(top[7].children[0].values, check.DeepEquals,
+		[]*value{{r: 0x80, g: 0x80, b: 0x80, count: 2}})
+	c.Check(top[7].children[7].values, check.DeepEquals,
+		[]*value{{r: 0xC0, g: 0xC0, b: 0xC0, count: 1}})
+}
+
+func (*SparseSuite) TestSparseDepth1(c *check.C) {
+	oct, err := NewOctree(1, WithStorage(SparseStorage))
+	c.Assert(err, check.IsNil)
+	c.Check(oct.FindClosest(1, 2, 3), check.DeepEquals, value{})
+	oct.Add(1, 2, 3)
+	c.Check(oct.root.values, check.DeepEquals,
+		[]*value{{r: 1, g: 2, b: 3, count: 1}})
+	c.Check(oct.FindClosest(0xFF, 0xFF, 0xFF), check.DeepEquals,
+		value{r: 1, g: 2, b: 3, count: 1})
+}
+
+// Sparse trees must give the same answers as dense ones
+func checkSparseMatchesDense(c *check.C, depth int, ordering Ordering) {
+	dense, err := NewOctree(depth, WithOrdering(ordering), WithStorage(DenseStorage))
+	c.Assert(err, check.IsNil)
+	sparse, err := NewOctree(depth, WithOrdering(ordering), WithStorage(SparseStorage))
+	c.Assert(err, check.IsNil)
+	rng := rand.New(rand.NewSource(int64(depth)))
+	for i := 0; i < 300; i++ {
+		r, g, b := uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256))
+		dense.Add(r, g, b)
+		sparse.Add(r, g, b)
+	}
+	for i := 0; i < 300; i++ {
+		r, g, b := uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256))
+		d := dense.FindClosest(r, g, b)
+		s := sparse.FindClosest(r, g, b)
+		// Ties could be broken either way, so compare the distances
+		c.Check(dist2ToV(r, g, b, &s), check.Equals, dist2ToV(r, g, b, &d))
+	}
+	c.Check(sparse.FindInBox(0x20, 0x40, 0x00, 0x90, 0xFF, 0x7F), check.DeepEquals,
+		dense.FindInBox(0x20, 0x40, 0x00, 0x90, 0xFF, 0x7F))
+	c.Check(sparse.FindWithin(0x80, 0x80, 0x80, 0x40), check.DeepEquals,
+		dense.FindWithin(0x80, 0x80, 0x80, 0x40))
+}
+
+func (*SparseSuite) TestSparseMatchesDense(c *check.C) {
+	for depth := 1; depth <= 7; depth++ {
+		checkSparseMatchesDense(c, depth, MortonOrder)
+		checkSparseMatchesDense(c, depth, HilbertOrder)
+	}
+}
@@ -0,0 +1,96 @@
+package octree
+
+import (
+	"slices"
+)
+
+// Stats summarizes what is stored in a tree.
+type Stats struct {
+	// The total of all counts
+	Count uint64
+	// The number of distinct colors
+	Distinct int
+	// One entry for each level below the root
+	Layers []LayerStats
+	// The leaves that hold the most distinct colors, longest first
+	LongestLeaves []LeafStats
+}
+
+// LayerStats summarizes the blocks at one level of the tree.
+type LayerStats struct {
+	// The root is level 0, so this starts at 1
+	Level int
+	// The number of blocks that hold any colors
+	Occupied int
+	// The number of blocks that there could be at this level
+	Blocks int
+	// The largest count of any one block
+	MaxCount uint64
+}
+
+// LeafStats describes one leaf of the tree.
+type LeafStats struct {
+	Level int
+	// The inclusive bounds of the block, as r, g, b
+	Min, Max [3]uint8
+	// The number of distinct colors in the leaf
+	Distinct int
+	// The total of their counts
+	Count uint64
+}
+
+// Stats walks the tree to summarize it. Up to longest of the leaves holding
+// the most distinct colors are listed. Leaves with the same number of colors
+// are sorted by count, and then by the order of their blocks.
+func (o *Octree) Stats(longest int) Stats {
+	stats := Stats{Count: o.count}
+	for level := 1; level < o.depth; level++ {
+		stats.Layers = append(stats.Layers, LayerStats{Level: level, Blocks: 1 << (3 * level)})
+	}
+	var leaves []LeafStats
+	var walk func(c cursor)
+	walk = func(c cursor) {
+		if c.level > 0 {
+			layer := &stats.Layers[c.level-1]
+			layer.Occupied++
+			layer.MaxCount = max(layer.MaxCount, o.cursorCount(c))
+		}
+		if o.cursorIsLeaf(c) {
+			values := o.cursorValues(c)
+			stats.Distinct += len(values)
+			if longest > 0 && len(values) > 0 {
+				vMin, vMax := o.cursorMinMax(c)
+				leaves = append(leaves, LeafStats{
+					Level:    int(c.level),
+					Min:      [3]uint8{vMin.r, vMin.g, vMin.b},
+					Max:      [3]uint8{vMax.r, vMax.g, vMax.b},
+					Distinct: len(values),
+					Count:    o.cursorCount(c),
+				})
+			}
+			return
+		}
+		var buf [8]cursor
+		for _, child := range o.appendChildren(buf[:0], c) {
+			walk(child)
+		}
+	}
+	walk(o.rootCursor())
+	slices.SortStableFunc(leaves, func(x, y LeafStats) int {
+		if x.Distinct != y.Distinct {
+			return y.Distinct - x.Distinct
+		}
+		if x.Count != y.Count {
+			if x.Count > y.Count {
+				return -1
+			}
+			return 1
+		}
+		return 0
+	})
+	if len(leaves) > longest {
+		leaves = leaves[:longest]
+	}
+	stats.LongestLeaves = leaves
+	return stats
+}
@@ -0,0 +1,70 @@
+package octree
+
+import (
+	"gopkg.in/check.v1"
+)
+
+type StatsSuite struct{}
+
+var _ = check.Suite(&StatsSuite{})
+
+func (*StatsSuite) TestStatsEmpty(c *check.C) {
+	oct, err := NewOctree(3)
+	c.Assert(err, check.IsNil)
+	c.Check(oct.Stats(5), check.DeepEquals, Stats{
+		Layers: []LayerStats{
+			{Level: 1, Blocks: 8},
+			{Level: 2, Blocks: 64},
+		},
+	})
+}
+
+func (*StatsSuite) TestStats(c *check.C) {
+	for _, options := range [][]Option{nil, {WithStorage(SparseStorage)}} {
+		oct, err := NewOctree(3, options...)
+		c.Assert(err, check.IsNil)
+		oct.Add(0x00, 0x00, 0x00)
+		oct.Add(0x01, 0x00, 0x00)
+		oct.Add(0x01, 0x00, 0x00)
+		oct.Add(0x02, 0x00, 0x00)
+		oct.Add(0x50, 0x00, 0x00)
+		oct.Add(0xFF, 0xFF, 0xFF)
+		oct.Add(0xFE, 0xFF, 0xFF)
+		c.Check(oct.Stats(2), check.DeepEquals, Stats{
+			Count:    7,
+			Distinct: 6,
+			Layers: []LayerStats{
+				{Level: 1, Occupied: 2, Blocks: 8, MaxCount: 5},
+				{Level: 2, Occupied: 3, Blocks: 64, MaxCount: 4},
+			},
+			LongestLeaves: []LeafStats{
+				{Level: 2, Min: [3]uint8{0, 0, 0}, Max: [3]uint8{0x3F, 0x3F, 0x3F}, Distinct: 3, Count: 4},
+				{Level: 2, Min: [3]uint8{0xC0, 0xC0, 0xC0}, Max: [3]uint8{0xFF, 0xFF, 0xFF}, Distinct: 2, Count: 2},
+			},
+		})
+		c.Check(oct.Stats(0).LongestLeaves, check.HasLen, 0)
+		c.Check(oct.Stats(10).LongestLeaves, check.HasLen, 3)
+	}
+}
+
+func (*StatsSuite) TestStatsAdaptive(c *check.C) {
+	oct, err := NewOctree(9, WithAdaptiveLeaves(2))
+	c.Assert(err, check.IsNil)
+	oct.Add(0x00, 0x00, 0x00)
+	oct.Add(0x80, 0x00, 0x00)
+	stats := oct.Stats(1)
+	// Everything still fits in the root
+	c.Check(stats.Distinct, check.Equals, 2)
+	c.Check(stats.Layers, check.HasLen, 8)
+	c.Check(stats.Layers[0].Occupied, check.Equals, 0)
+	c.Check(stats.LongestLeaves, check.DeepEquals, []LeafStats{
+		{Level: 0, Max: [3]uint8{0xFF, 0xFF, 0xFF}, Distinct: 2, Count: 2},
+	})
+	oct.Add(0xFF, 0x00, 0x00)
+	stats = oct.Stats(1)
+	c.Check(stats.Layers[0].Occupied, check.Equals, 2)
+	c.Check(stats.Layers[1].Occupied, check.Equals, 0)
+	c.Check(stats.LongestLeaves, check.DeepEquals, []LeafStats{
+		{Level: 1, Min: [3]uint8{0x80, 0, 0}, Max: [3]uint8{0xFF, 0x7F, 0x7F}, Distinct: 2, Count: 2},
+	})
+}
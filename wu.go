@@ -0,0 +1,191 @@
+package octree
+
+// Wu's quantizer works on a histogram with 32 bins a channel. The tables of
+// moments have an extra row of zeros in each direction, so that the sums
+// over a box can be read off from its corners.
+const (
+	wuBits = 5
+	wuSide = 1<<wuBits + 1
+)
+
+// The cumulative moments of the histogram: the count, the sums of each
+// channel, and the sum of the squared lengths of the colors, each summed over
+// the bins up to and including the given one in every channel.
+type wuMoments struct {
+	count, sumR, sumG, sumB, sumSq []float64
+}
+
+// A box of bins, from lo+1 up to hi in each channel.
+type wuBox struct {
+	lo, hi [3]int
+}
+
+func wuIndex(r, g, b int) int {
+	return (r*wuSide+g)*wuSide + b
+}
+
+// The bin of r,g,b in each channel, from 1.
+func wuBin(r, g, b uint8) (int, int, int) {
+	return int(r>>(8-wuBits)) + 1, int(g>>(8-wuBits)) + 1, int(b>>(8-wuBits)) + 1
+}
+
+// Split the values into at most n boxes with Wu's algorithm. The box with the
+// largest variance is cut next, at whichever place along one of its channels
+// leaves the two halves with the least variance between them. Colors in the
+// same bin can't be split up, as the cuts are between bins. metric is only
+// used for the error of the groups, as the cuts always minimize the squared
+// RGB distance.
+func wuQuantize(values []*value, n int, metric Metric) []*quantizeGroup {
+	m := newWuMoments(values)
+	boxes := []wuBox{{hi: [3]int{wuSide - 1, wuSide - 1, wuSide - 1}}}
+	variances := []float64{m.variance(boxes[0])}
+	for len(boxes) < n {
+		next := 0
+		for i, v := range variances {
+			if v > variances[next] {
+				next = i
+			}
+		}
+		if variances[next] <= 0 {
+			break
+		}
+		first, second, ok := m.cut(boxes[next])
+		if !ok {
+			variances[next] = 0
+			continue
+		}
+		boxes[next] = first
+		variances[next] = m.variance(first)
+		boxes = append(boxes, second)
+		variances = append(variances, m.variance(second))
+	}
+	// Which box each bin ended up in
+	tags := make([]int, wuSide*wuSide*wuSide)
+	for i, box := range boxes {
+		for r := box.lo[0] + 1; r <= box.hi[0]; r++ {
+			for g := box.lo[1] + 1; g <= box.hi[1]; g++ {
+				for b := box.lo[2] + 1; b <= box.hi[2]; b++ {
+					tags[wuIndex(r, g, b)] = i
+				}
+			}
+		}
+	}
+	boxValues := make([][]*value, len(boxes))
+	for _, v := range values {
+		i := tags[wuIndex(wuBin(v.r, v.g, v.b))]
+		boxValues[i] = append(boxValues[i], v)
+	}
+	groups := make([]*quantizeGroup, len(boxes))
+	for i, values := range boxValues {
+		groups[i] = newQuantizeGroup(metric, values...)
+	}
+	return groups
+}
+
+func newWuMoments(values []*value) *wuMoments {
+	size := wuSide * wuSide * wuSide
+	m := &wuMoments{
+		count: make([]float64, size),
+		sumR:  make([]float64, size),
+		sumG:  make([]float64, size),
+		sumB:  make([]float64, size),
+		sumSq: make([]float64, size),
+	}
+	for _, v := range values {
+		i := wuIndex(wuBin(v.r, v.g, v.b))
+		count := float64(v.count)
+		r, g, b := float64(v.r), float64(v.g), float64(v.b)
+		m.count[i] += count
+		m.sumR[i] += count * r
+		m.sumG[i] += count * g
+		m.sumB[i] += count * b
+		m.sumSq[i] += count * (r*r + g*g + b*b)
+	}
+	for _, table := range [][]float64{m.count, m.sumR, m.sumG, m.sumB, m.sumSq} {
+		cumulate(table)
+	}
+	return m
+}
+
+// Turn a table of the bins into one of the sums of all the bins up to each.
+func cumulate(table []float64) {
+	for r := 1; r < wuSide; r++ {
+		var area [wuSide]float64
+		for g := 1; g < wuSide; g++ {
+			line := 0.0
+			for b := 1; b < wuSide; b++ {
+				line += table[wuIndex(r, g, b)]
+				area[b] += line
+				table[wuIndex(r, g, b)] = table[wuIndex(r-1, g, b)] + area[b]
+			}
+		}
+	}
+}
+
+// The sum of table over the bins of box, from its 8 corners.
+func (box wuBox) sum(table []float64) float64 {
+	total := 0.0
+	for corner := 0; corner < 8; corner++ {
+		var at [3]int
+		sign := 1.0
+		for channel := 0; channel < 3; channel++ {
+			if corner&(1<<channel) != 0 {
+				at[channel] = box.hi[channel]
+			} else {
+				at[channel] = box.lo[channel]
+				sign = -sign
+			}
+		}
+		total += sign * table[wuIndex(at[0], at[1], at[2])]
+	}
+	return total
+}
+
+// The total count and channel sums of box.
+func (m *wuMoments) totals(box wuBox) (count float64, sums [3]float64) {
+	return box.sum(m.count), [3]float64{box.sum(m.sumR), box.sum(m.sumG), box.sum(m.sumB)}
+}
+
+// How far the colors in box are from their mean, as a sum of squared
+// distances weighted by count. A box of one bin can't be cut, so it is 0.
+func (m *wuMoments) variance(box wuBox) float64 {
+	if box.hi[0]-box.lo[0] <= 1 && box.hi[1]-box.lo[1] <= 1 && box.hi[2]-box.lo[2] <= 1 {
+		return 0
+	}
+	count, sums := m.totals(box)
+	if count == 0 {
+		return 0
+	}
+	return box.sum(m.sumSq) - (sums[0]*sums[0]+sums[1]*sums[1]+sums[2]*sums[2])/count
+}
+
+// Cut box in two where it most reduces the variance, which is where the
+// squared length of the sums over the count of each half adds up to the
+// most. ok is false if there's nowhere to cut that leaves colors in both
+// halves.
+func (m *wuMoments) cut(box wuBox) (first, second wuBox, ok bool) {
+	count, sums := m.totals(box)
+	best := 0.0
+	for channel := 0; channel < 3; channel++ {
+		for at := box.lo[channel] + 1; at < box.hi[channel]; at++ {
+			low := box
+			low.hi[channel] = at
+			lowCount, lowSums := m.totals(low)
+			highCount := count - lowCount
+			if lowCount == 0 || highCount == 0 {
+				continue
+			}
+			score := 0.0
+			for i := range sums {
+				highSum := sums[i] - lowSums[i]
+				score += lowSums[i]*lowSums[i]/lowCount + highSum*highSum/highCount
+			}
+			if !ok || score > best {
+				high := box
+				high.lo[channel] = at
+				first, second, best, ok = low, high, score, true
+			}
+		}
+	}
+	return first, second, ok
+}
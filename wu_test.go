@@ -0,0 +1,59 @@
+package octree
+
+import (
+	"math"
+
+	"gopkg.in/check.v1"
+)
+
+type WuSuite struct{}
+
+var _ = check.Suite(&WuSuite{})
+
+func (*WuSuite) TestWuMoments(c *check.C) {
+	values := []*value{
+		{r: 0x00, g: 0x00, b: 0x00, count: 2},
+		{r: 0x08, g: 0x00, b: 0x00, count: 1},
+		{r: 0xFF, g: 0xFF, b: 0x10, count: 3},
+	}
+	m := newWuMoments(values)
+	whole := wuBox{hi: [3]int{wuSide - 1, wuSide - 1, wuSide - 1}}
+	count, sums := m.totals(whole)
+	c.Check(count, check.Equals, 6.0)
+	c.Check(sums, check.Equals, [3]float64{8 + 3*0xFF, 3 * 0xFF, 3 * 0x10})
+	// The first two bins of red
+	count, sums = m.totals(wuBox{hi: [3]int{2, wuSide - 1, wuSide - 1}})
+	c.Check(count, check.Equals, 3.0)
+	c.Check(sums, check.Equals, [3]float64{8, 0, 0})
+	// Only the first bin of each
+	c.Check(wuBox{hi: [3]int{1, 1, 1}}.sum(m.count), check.Equals, 2.0)
+	c.Check(m.variance(wuBox{hi: [3]int{1, 1, 1}}), check.Equals, 0.0)
+	mean := 8.0 / 3
+	expected := 2*mean*mean + (8-mean)*(8-mean)
+	c.Check(math.Abs(m.variance(wuBox{hi: [3]int{2, 1, 1}})-expected) < 1e-9, check.Equals, true)
+	// The cut is between the dark and light colors
+	first, second, ok := m.cut(whole)
+	c.Assert(ok, check.Equals, true)
+	count, _ = m.totals(first)
+	c.Check(count, check.Equals, 3.0)
+	count, _ = m.totals(second)
+	c.Check(count, check.Equals, 3.0)
+	// A box with one bin in it can't be cut
+	_, _, ok = m.cut(wuBox{hi: [3]int{1, 1, 1}})
+	c.Check(ok, check.Equals, false)
+}
+
+func (*WuSuite) TestWuSameBin(c *check.C) {
+	oct, err := NewOctree(8)
+	c.Assert(err, check.IsNil)
+	// Colors in the same bin stay together, however much room there is
+	oct.Add(0x10, 0x20, 0x30)
+	oct.Add(0x11, 0x20, 0x30)
+	oct.Add(0xF0, 0x00, 0x00)
+	palette, err := oct.QuantizeWith(WuQuantizer, 3, EuclideanMetric)
+	c.Assert(err, check.IsNil)
+	c.Check(palette, check.DeepEquals, []PaletteColor{
+		{R: 0x11, G: 0x20, B: 0x30, Count: 2},
+		{R: 0xF0, Count: 1},
+	})
+}